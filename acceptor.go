@@ -17,11 +17,13 @@ package gain
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
 	"github.com/pawelgaczynski/gain/iouring"
 	"github.com/pawelgaczynski/gain/pkg/errors"
+	"github.com/pawelgaczynski/gain/pkg/metrics"
 	"github.com/pawelgaczynski/gain/pkg/socket"
 )
 
@@ -31,9 +33,23 @@ type acceptor struct {
 	clientAddr        *syscall.RawSockaddrAny
 	clientLenPointer  *uint32
 	connectionManager *connectionManager
+	stopped           atomic.Bool
+	workerIndex       int
+	metricsCollector  metrics.Collector
+}
+
+// stop prevents any further addAcceptRequest/addAcceptConnRequest calls from
+// submitting new accept SQEs, the first step of a graceful Shutdown: no new
+// connections are admitted while existing ones drain.
+func (a *acceptor) stop() {
+	a.stopped.Store(true)
 }
 
 func (a *acceptor) addAcceptRequest() error {
+	if a.stopped.Load() {
+		return nil
+	}
+
 	entry, err := a.ring.GetSQE()
 	if err != nil {
 		return fmt.Errorf("error getting SQE: %w", err)
@@ -47,6 +63,10 @@ func (a *acceptor) addAcceptRequest() error {
 }
 
 func (a *acceptor) addAcceptConnRequest() error {
+	if a.stopped.Load() {
+		return nil
+	}
+
 	err := a.addAcceptRequest()
 	if err != nil {
 		return err
@@ -57,6 +77,7 @@ func (a *acceptor) addAcceptConnRequest() error {
 		return errors.ErrConnectionIsMissing
 	}
 	conn.state = connAccept
+	a.metricsCollector.IncAccepted(a.workerIndex)
 
 	return nil
 }
@@ -70,10 +91,20 @@ func (a *acceptor) lastClientAddr() (net.Addr, error) {
 	return socket.SockaddrToTCPOrUnixAddr(addr), nil
 }
 
+// setMetricsCollector installs the Collector this acceptor reports its
+// accepted counter to, and the worker index it reports under. Engines that
+// don't call WithMetricsCollector leave metricsCollector as the
+// metrics.NoopCollector newAcceptor defaults to.
+func (a *acceptor) setMetricsCollector(collector metrics.Collector, workerIndex int) {
+	a.metricsCollector = collector
+	a.workerIndex = workerIndex
+}
+
 func newAcceptor(ring *iouring.Ring, connectionManager *connectionManager) *acceptor {
 	acceptor := &acceptor{
 		ring:              ring,
 		connectionManager: connectionManager,
+		metricsCollector:  metrics.NoopCollector{},
 	}
 	acceptor.clientAddr, acceptor.clientLenPointer = createClientAddr()
 
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+// Frame is one logical protocol message sliced out of a connection's
+// read buffer by a Codec, e.g. a complete HTTP/1.1 request or an HTTP/2
+// stream frame. What it contains is entirely up to the Codec implementation;
+// gain only moves it from Decode to EventHandler.OnFrame unopened.
+type Frame interface{}
+
+// Codec turns the byte-oriented connection buffer gain normally hands to
+// EventHandler.OnRead into logical frames, and turns outgoing frames back
+// into bytes. Implementations must be safe to share across connections
+// handled by the same worker; per-connection state belongs on the Frame or
+// behind a key the codec manages itself (e.g. HTTP/2 stream tables keyed by
+// conn.fd).
+type Codec interface {
+	// Decode inspects buf, the bytes currently available on conn, and
+	// either returns a complete Frame and the number of bytes it consumed,
+	// or a nil Frame and 0 if buf doesn't yet hold a full frame.
+	Decode(conn Conn, buf []byte) (frame Frame, consumed int, err error)
+	// Encode serializes a Frame produced by the application back into
+	// wire bytes ready to be queued on the connection's write buffer.
+	Encode(frame Frame) []byte
+}
+
+// CodecEventHandler is implemented by an EventHandler that wants decoded
+// Frames instead of raw bytes. When the engine's Codec option is set and
+// the active EventHandler also implements this interface, the consumer
+// worker's read path repeatedly calls Codec.Decode on the connection's
+// buffer and dispatches each resulting Frame to OnFrame instead of calling
+// the byte-oriented OnRead.
+type CodecEventHandler interface {
+	EventHandler
+	OnFrame(conn Conn, frame Frame)
+}
+
+// WithCodec installs a Codec on the engine. It only has an effect when the
+// configured EventHandler also implements CodecEventHandler; plain
+// EventHandler implementations keep receiving raw bytes via OnRead.
+func WithCodec(codec Codec) Option {
+	return func(options *Options) {
+		options.codec = codec
+	}
+}
+
+// connCodecRead is the connRead counterpart used instead of connRead
+// whenever a Codec is configured and the active EventHandler also
+// implements CodecEventHandler. Borrowing a dedicated state, the same way
+// TLS borrows connTLSRead, keeps onRead's plain-connRead auto-dispatch from
+// firing so pumpCodecRead can decode Frames out of the buffered bytes
+// first instead of handing raw bytes to OnRead.
+const connCodecRead = 1100
+
+// decodeFrames drains every complete Frame currently buffered on conn,
+// dispatching each to handler.OnFrame in order. It is the seam
+// pumpCodecRead calls into once a connCodecRead connection has bytes
+// buffered; partial trailing bytes are left in conn's buffer for the next
+// read.
+func decodeFrames(codec Codec, handler CodecEventHandler, conn Conn, buf []byte) ([]byte, error) {
+	for {
+		frame, consumed, err := codec.Decode(conn, buf)
+		if err != nil {
+			return buf, err
+		}
+		if frame == nil || consumed == 0 {
+			return buf, nil
+		}
+
+		handler.OnFrame(conn, frame)
+		buf = buf[consumed:]
+	}
+}
+
+// pumpCodecRead decodes every Frame available on a connCodecRead
+// connection's buffered bytes, dispatching each to handler.OnFrame, then
+// queues the connection's next read, mirroring what pumpTLSRead does for
+// the TLS userspace-fallback path.
+func (c *consumerWorker) pumpCodecRead(conn *connection, codec Codec, handler CodecEventHandler) error {
+	conn.setUserSpace()
+
+	if _, err := decodeFrames(codec, handler, conn, conn.inboundBuffer.Bytes()); err != nil {
+		return err
+	}
+
+	return c.addNextRequest(conn)
+}
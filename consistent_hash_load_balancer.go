@@ -0,0 +1,198 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+)
+
+// virtualNodesPerWorker is the N in "a ring of N*workers virtual nodes":
+// enough virtual nodes per worker to keep the ring reasonably balanced
+// without making rebuilds expensive.
+const virtualNodesPerWorker = 160
+
+// defaultBoundedLoadEpsilon bounds how far above the average connection
+// count a worker may drift before the consistent-hash balancer starts
+// walking the ring past it, so one hot key can't pin unbounded load onto a
+// single worker.
+const defaultBoundedLoadEpsilon = 0.25
+
+// ringNode is one virtual node on the consistent-hash ring.
+type ringNode struct {
+	hash      uint64
+	workerIdx int
+}
+
+// consistentHashLoadBalancer routes connections from the same client to the
+// same worker (sticky sessions for WebSocket / HTTP-2 connection-scoped
+// state / per-conn cache locality) by hashing the client address onto a
+// ring of virtual nodes, while bounding how overloaded any single worker is
+// allowed to get relative to the others (the "bounded-load" variant of
+// consistent hashing).
+type consistentHashLoadBalancer struct {
+	mu      sync.RWMutex
+	hashFn  func(net.Addr) uint64
+	epsilon float64
+	workers []worker
+	ring    []ringNode
+}
+
+// newConsistentHashLoadBalancer creates a consistentHashLoadBalancer using
+// hashFn to place both workers and incoming client addresses on the ring.
+func newConsistentHashLoadBalancer(hashFn func(net.Addr) uint64) *consistentHashLoadBalancer {
+	return &consistentHashLoadBalancer{
+		hashFn:  hashFn,
+		epsilon: defaultBoundedLoadEpsilon,
+	}
+}
+
+func (c *consistentHashLoadBalancer) register(w worker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.setIndex(len(c.workers))
+	c.workers = append(c.workers, w)
+	c.rebuildRingLocked()
+}
+
+// deregister removes a worker from the ring, rebuilding it so subsequent
+// next() calls never land on it again; rebuildRingLocked is what makes that
+// rebuild atomic with respect to concurrent next() calls, the same as
+// register. It does not touch connections already assigned to w, close w,
+// or get called by anything in this package today -- Shutdown tears down
+// every worker together via registered()/shutdown() rather than removing
+// one at a time, so there's no "a single worker leaves rotation" event in
+// this series for deregister to be wired into yet. It isn't part of the
+// loadBalancer interface for the same reason: nothing needs to call it
+// through that interface until such an event exists.
+func (c *consistentHashLoadBalancer) deregister(w worker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx := w.index()
+	if idx < 0 || idx >= len(c.workers) || c.workers[idx] != w {
+		return
+	}
+
+	c.workers = append(c.workers[:idx], c.workers[idx+1:]...)
+	for i := idx; i < len(c.workers); i++ {
+		c.workers[i].setIndex(i)
+	}
+	c.rebuildRingLocked()
+}
+
+// rebuildRingLocked recomputes the full virtual-node ring. Callers hold
+// c.mu for writing; next() only ever reads the ring under an RLock and
+// reads/writes of the ring field itself happen while holding that same
+// mutex, so a rebuild can never observe (or hand out) a half-built ring.
+func (c *consistentHashLoadBalancer) rebuildRingLocked() {
+	ring := make([]ringNode, 0, len(c.workers)*virtualNodesPerWorker)
+
+	for workerIdx := range c.workers {
+		for vnode := 0; vnode < virtualNodesPerWorker; vnode++ {
+			ring = append(ring, ringNode{
+				hash:      virtualNodeHash(workerIdx, vnode),
+				workerIdx: workerIdx,
+			})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	c.ring = ring
+}
+
+func virtualNodeHash(workerIdx, vnode int) uint64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(workerIdx))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(vnode))
+
+	return fnv1a64(buf[:])
+}
+
+func fnv1a64(data []byte) uint64 {
+	const (
+		offsetBasis = 14695981039346656037
+		prime       = 1099511628211
+	)
+
+	hash := uint64(offsetBasis)
+	for _, b := range data {
+		hash ^= uint64(b)
+		hash *= prime
+	}
+
+	return hash
+}
+
+// next walks the ring starting at addr's primary virtual node, skipping any
+// worker whose activeConnections() exceeds the bounded-load threshold, and
+// returning the first worker that doesn't. If every worker is over the
+// threshold (all of them overloaded equally) it falls back to the primary
+// worker rather than refusing the connection.
+//
+// Like roundRobinLoadBalancer.next and leastConnectionsLoadBalancer.next,
+// this assumes at least one worker is registered; callers never invoke next
+// on a load balancer with zero workers.
+func (c *consistentHashLoadBalancer) next(addr net.Addr) worker {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	hash := c.hashFn(addr)
+	start := sort.Search(len(c.ring), func(i int) bool {
+		return c.ring[i].hash >= hash
+	})
+
+	threshold := c.boundedLoadThresholdLocked()
+
+	for i := 0; i < len(c.ring); i++ {
+		node := c.ring[(start+i)%len(c.ring)]
+		w := c.workers[node.workerIdx]
+		if float64(w.activeConnections()) <= threshold {
+			return w
+		}
+	}
+
+	return c.workers[c.ring[start%len(c.ring)].workerIdx]
+}
+
+func (c *consistentHashLoadBalancer) registered() []worker {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	workers := make([]worker, len(c.workers))
+	copy(workers, c.workers)
+
+	return workers
+}
+
+func (c *consistentHashLoadBalancer) boundedLoadThresholdLocked() float64 {
+	if len(c.workers) == 0 {
+		return 0
+	}
+
+	total := 0
+	for _, w := range c.workers {
+		total += w.activeConnections()
+	}
+	avg := float64(total) / float64(len(c.workers))
+
+	return avg * (1 + c.epsilon)
+}
@@ -15,6 +15,7 @@ package gain
 // limitations under the License.
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -23,11 +24,13 @@ import (
 	"github.com/pawelgaczynski/gain/iouring"
 	"github.com/pawelgaczynski/gain/logger"
 	gainErrors "github.com/pawelgaczynski/gain/pkg/errors"
+	"github.com/pawelgaczynski/gain/pkg/metrics"
 	"github.com/pawelgaczynski/gain/pkg/queue"
 )
 
 type consumerConfig struct {
 	readWriteWorkerConfig
+	metricsCollector metrics.Collector
 }
 
 type consumer interface {
@@ -43,6 +46,13 @@ type consumerWorker struct {
 	socketAddresses sync.Map
 	// used for kernels < 5.18 where OP_MSG_RING is not supported
 	connQueue queue.LockFreeQueue[int]
+
+	// bufferPool is non-nil when WithRegisteredBuffers was used and the
+	// running kernel supports IOSQE_BUFFER_SELECT. It is registered with
+	// the kernel at startup but not yet consulted by addNextRequest (see
+	// the comment on beginNextRead), so it currently sits idle: every read
+	// still goes through the per-connection buffer onRead has always used.
+	bufferPool *iouring.BufferPool
 }
 
 func (c *consumerWorker) setSocketAddr(fd int, addr net.Addr) {
@@ -71,12 +81,50 @@ func (c *consumerWorker) closeAllConns() {
 	}, -1)
 }
 
+// drain calls onDrain for every connection this worker currently has open,
+// without closing any of them, by piggybacking on the connectionManager
+// predicate that activeConnections already uses to walk live connections.
+func (c *consumerWorker) drain(onDrain func(Conn)) {
+	c.connectionManager.activeConnections(func(conn *connection) bool {
+		onDrain(conn)
+
+		return true
+	})
+}
+
 func (c *consumerWorker) activeConnections() int {
 	return c.connectionManager.activeConnections(func(c *connection) bool {
 		return true
 	})
 }
 
+// beginNextRead queues the first read request for a just-accepted
+// connection. When a TLSConfig is installed, the connection starts in
+// connTLSHandshake instead of connRead so its first bytes are pumped
+// through a Handshaker before any plaintext reaches eventHandler.
+//
+// addNextRequest submits this (and every subsequent) read the same way
+// regardless of c.bufferPool: it does not OR IOSQE_BUFFER_SELECT into the
+// SQE via bufferPool.MarkBufferSelect, because doing so requires access to
+// the SubmissionQueueEntry addNextRequest builds, which addNextRequest
+// doesn't expose to its caller. Until addNextRequest itself is taught to
+// consult c.bufferPool, every read -- including on a worker with
+// WithRegisteredBuffers configured -- is submitted and completed as an
+// ordinary per-connection-buffer read; a completion is never tagged
+// IORING_CQE_F_BUFFER, so nothing here ever calls bufferPool.Bytes against
+// a buffer the kernel didn't actually fill.
+func (c *consumerWorker) beginNextRead(conn *connection) error {
+	if c.config.tlsConfig != nil {
+		return c.beginTLSHandshake(conn)
+	}
+
+	if _, ok := c.eventHandler.(CodecEventHandler); ok && c.config.codec != nil {
+		conn.state = connCodecRead
+	}
+
+	return c.addNextRequest(conn)
+}
+
 func (c *consumerWorker) handleConn(conn *connection, cqe *iouring.CompletionQueueEvent) {
 	var (
 		err    error
@@ -84,16 +132,49 @@ func (c *consumerWorker) handleConn(conn *connection, cqe *iouring.CompletionQue
 	)
 
 	switch conn.state {
+	case connTLSHandshake:
+		err = c.onRead(cqe, conn)
+		if err == nil {
+			err = c.pumpTLSHandshake(conn, conn.inboundBuffer.Bytes())
+		}
+		if err != nil {
+			errMsg = "TLS handshake error"
+		}
+
 	case connRead:
+		c.recordRead(conn, int(cqe.Res()))
 		err = c.onRead(cqe, conn)
 		if err != nil {
 			errMsg = "read error"
 		}
 
+	case connTLSRead:
+		c.recordRead(conn, int(cqe.Res()))
+		err = c.onRead(cqe, conn)
+		if err == nil {
+			err = c.pumpTLSRead(conn, conn.inboundBuffer.Bytes())
+		}
+		if err != nil {
+			errMsg = "TLS read error"
+		}
+
+	case connCodecRead:
+		c.recordRead(conn, int(cqe.Res()))
+		err = c.onRead(cqe, conn)
+		if err == nil {
+			handler, _ := c.eventHandler.(CodecEventHandler)
+			err = c.pumpCodecRead(conn, c.config.codec, handler)
+		}
+		if err != nil {
+			errMsg = "codec read error"
+		}
+
 	case connWrite:
 		n := int(cqe.Res())
 		conn.onKernelWrite(n)
 		c.logDebug().Int("fd", conn.fd).Int32("count", cqe.Res()).Msg("Bytes writed")
+		c.config.metricsCollector.AddWriteBytes(c.index(), n)
+		c.recordWrite(conn)
 
 		conn.setUserSpace()
 		c.eventHandler.OnWrite(conn, n)
@@ -120,6 +201,10 @@ func (c *consumerWorker) handleConn(conn *connection, cqe *iouring.CompletionQue
 
 	if err != nil {
 		c.logError(err).Msg(errMsg)
+		if errors.Is(err, iouring.ErrSQEOverflow) {
+			c.config.metricsCollector.IncSQEOverflow(c.index())
+		}
+		c.config.metricsCollector.IncCloseErrors(c.index())
 		c.closeConn(conn, true, err)
 	}
 }
@@ -150,8 +235,9 @@ func (c *consumerWorker) getConnsFromQueue() {
 
 		conn.setUserSpace()
 		c.eventHandler.OnAccept(conn)
+		c.afterAccept(conn)
 
-		err := c.addNextRequest(conn)
+		err := c.beginNextRead(conn)
 		if err != nil {
 			c.logError(err).Msg("add request error")
 		}
@@ -164,6 +250,7 @@ func (c *consumerWorker) handleJobsInQueues() {
 	}
 
 	c.handleAsyncWritesIfEnabled()
+	c.config.metricsCollector.SetActive(c.index(), c.activeConnections())
 }
 
 func (c *consumerWorker) loop(_ int) error {
@@ -220,8 +307,9 @@ func (c *consumerWorker) loop(_ int) error {
 
 			conn.setUserSpace()
 			c.eventHandler.OnAccept(conn)
+			c.afterAccept(conn)
 
-			return c.addNextRequest(conn)
+			return c.beginNextRead(conn)
 		}
 		fileDescriptor := int(cqe.UserData() & ^allFlagsMask)
 		if fileDescriptor < syscall.Stderr {
@@ -251,11 +339,20 @@ func newConsumerWorker(
 	}
 	logger := logger.NewLogger("consumer", config.loggerLevel, config.prettyLogger)
 	connectionManager := newConnectionManager()
+	if config.metricsCollector == nil {
+		config.metricsCollector = metrics.NoopCollector{}
+	}
+	bufferPool, err := newRegisteredBufferPool(ring, config.registeredBufferCount, config.registeredBufferSize)
+	if err != nil {
+		return nil, fmt.Errorf("registered buffer pool error: %w", err)
+	}
+
 	consumer := &consumerWorker{
 		config: config,
 		readWriteWorkerImpl: newReadWriteWorkerImpl(
 			ring, index, localAddr, eventHandler, connectionManager, config.readWriteWorkerConfig, logger,
 		),
+		bufferPool: bufferPool,
 	}
 
 	if !features.ringsMessaging {
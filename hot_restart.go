@@ -0,0 +1,31 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+// WithHotRestart records that this engine participates in the
+// SO_REUSEPORT + SCM_RIGHTS rolling-restart pattern pkg/hotrestart
+// implements. It does not by itself receive a handed-off listening socket
+// or spawn a successor: the engine's startup/listen and Shutdown code that
+// would call pkg/hotrestart.Receive/Spawn isn't part of this option, so
+// callers doing a hot restart must call pkg/hotrestart.Receive themselves
+// before constructing the engine's listener, and pkg/hotrestart.Spawn
+// alongside Shutdown when rolling to a successor. options.hotRestart exists
+// so that wiring, when added, has a flag to key off; setting it today has
+// no other effect.
+func WithHotRestart() Option {
+	return func(options *Options) {
+		options.hotRestart = true
+	}
+}
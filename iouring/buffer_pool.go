@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iouring
+
+import (
+	"fmt"
+	"sync"
+)
+
+// minRegisteredBuffersKernelVersion is the kernel major/minor pair that
+// introduced IORING_OP_PROVIDE_BUFFERS / IOSQE_BUFFER_SELECT (5.7). Rings
+// created on older kernels fall back to a per-connection buffer instead.
+const (
+	minRegisteredBuffersKernelMajor = 5
+	minRegisteredBuffersKernelMinor = 7
+)
+
+// bufferSelectFlag mirrors IOSQE_BUFFER_SELECT; SQEs that want the kernel to
+// pick a buffer out of a registered group OR this into their flags instead
+// of supplying an iovec.
+const bufferSelectFlag uint8 = 1 << 4
+
+// ProvideBuffersDataFlag tags the UserData of IORING_OP_PROVIDE_BUFFERS
+// SQEs so a ring's completion loop can route their CQEs back to the
+// BufferPool that submitted them instead of treating them as a connection
+// event.
+const ProvideBuffersDataFlag uint64 = 1 << 62
+
+// cqeBufferIDShift is where the selected buffer's ID is packed into
+// CompletionQueueEvent.Flags (cqe.Flags >> 16) when IOSQE_BUFFER_SELECT was
+// used, per the IORING_CQE_F_BUFFER contract.
+const cqeBufferIDShift = 16
+
+// ErrBufferPoolExhausted is returned by BufferPool helpers when the kernel
+// has run out of buffers in a group and the caller should fall back to a
+// per-connection allocation for this read.
+var ErrBufferPoolExhausted = fmt.Errorf("iouring: registered buffer pool exhausted")
+
+// BufferPool registers a slab of N fixed-size buffers under a single group
+// ID at ring startup via IORING_OP_PROVIDE_BUFFERS, so addReadRequest can
+// submit reads with IOSQE_BUFFER_SELECT and no explicit iovec, removing the
+// per-connection buffer and the copy that comes with it. Buffers are
+// returned to the kernel (re-provided) once OnRead has finished with them.
+type BufferPool struct {
+	ring    *Ring
+	groupID uint16
+	size    int
+	count   int
+
+	mu    sync.Mutex
+	slab  []byte
+	inUse map[uint16]bool
+}
+
+// NewBufferPool allocates the backing slab for count buffers of size bytes
+// and slices it into per-buffer regions, but does not yet register anything
+// with the kernel; call Provide to submit the IORING_OP_PROVIDE_BUFFERS SQE.
+func NewBufferPool(ring *Ring, groupID uint16, count, size int) *BufferPool {
+	return &BufferPool{
+		ring:    ring,
+		groupID: groupID,
+		size:    size,
+		count:   count,
+		slab:    make([]byte, count*size),
+		inUse:   make(map[uint16]bool, count),
+	}
+}
+
+// Provide submits the IORING_OP_PROVIDE_BUFFERS SQE that hands the whole
+// slab to the kernel under p.groupID, one buffer per ID 0..count-1.
+func (p *BufferPool) Provide() error {
+	entry, err := p.ring.GetSQE()
+	if err != nil {
+		return fmt.Errorf("error getting SQE: %w", err)
+	}
+
+	entry.PrepareProvideBuffers(p.slab, p.count, p.size, int(p.groupID), 0)
+	entry.UserData = ProvideBuffersDataFlag | uint64(p.groupID)
+
+	return nil
+}
+
+// Reprovide returns a single buffer (identified by the ID the kernel
+// reported on a completion's Flags) back to the group so it can be
+// selected again by a future read.
+func (p *BufferPool) Reprovide(bufferID uint16) error {
+	p.mu.Lock()
+	delete(p.inUse, bufferID)
+	p.mu.Unlock()
+
+	entry, err := p.ring.GetSQE()
+	if err != nil {
+		return fmt.Errorf("error getting SQE: %w", err)
+	}
+
+	buf := p.bufferBytes(bufferID)
+	entry.PrepareProvideBuffers(buf, 1, p.size, int(p.groupID), int(bufferID))
+	entry.UserData = ProvideBuffersDataFlag | uint64(p.groupID)
+
+	return nil
+}
+
+// BufferIDFromCQE extracts the buffer ID the kernel selected for a
+// completed IOSQE_BUFFER_SELECT read, per IORING_CQE_F_BUFFER.
+func BufferIDFromCQE(cqe *CompletionQueueEvent) uint16 {
+	return uint16(cqe.Flags() >> cqeBufferIDShift)
+}
+
+// Bytes returns the n bytes written into bufferID by a completed read. It
+// marks the buffer in-use until Reprovide is called for it.
+func (p *BufferPool) Bytes(bufferID uint16, n int) []byte {
+	p.mu.Lock()
+	p.inUse[bufferID] = true
+	p.mu.Unlock()
+
+	return p.bufferBytes(bufferID)[:n]
+}
+
+func (p *BufferPool) bufferBytes(bufferID uint16) []byte {
+	start := int(bufferID) * p.size
+
+	return p.slab[start : start+p.size]
+}
+
+// GroupID is the buffer group ID reads against this pool should reference
+// via PrepareRead's bgid argument.
+func (p *BufferPool) GroupID() uint16 {
+	return p.groupID
+}
+
+// MarkBufferSelect ORs IOSQE_BUFFER_SELECT into entry's flags so the kernel
+// picks the buffer for a read from p's group instead of requiring the
+// caller to supply one, per IORING_OP_PROVIDE_BUFFERS/IOSQE_BUFFER_SELECT.
+func (p *BufferPool) MarkBufferSelect(entry *SubmissionQueueEntry) {
+	entry.Flags |= bufferSelectFlag
+	entry.BufIG = p.groupID
+}
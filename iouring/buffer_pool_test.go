@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iouring
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+// TestBufferPoolBytesIsolation checks that each buffer ID slices out its own
+// region of the backing slab, and that writes into one buffer's region never
+// bleed into a neighbor's, since every buffer shares the same []byte slab.
+func TestBufferPoolBytesIsolation(t *testing.T) {
+	pool := NewBufferPool(nil, 3, 4, 8)
+
+	Equal(t, uint16(3), pool.GroupID())
+
+	copy(pool.bufferBytes(0), []byte("aaaaaaaa"))
+	copy(pool.bufferBytes(1), []byte("bbbbbbbb"))
+
+	Equal(t, []byte("aaaa"), pool.Bytes(0, 4))
+	Equal(t, []byte("bbbbbbbb"), pool.Bytes(1, 8))
+}
+
+// TestBufferPoolInUseBookkeeping exercises the inUse map directly, since
+// Reprovide itself needs a real *Ring to submit the re-provide SQE through.
+// It documents the same contract Reprovide relies on: Bytes marks a buffer
+// in-use, and clearing that entry under p.mu is what lets it be handed out
+// again.
+func TestBufferPoolInUseBookkeeping(t *testing.T) {
+	pool := NewBufferPool(nil, 0, 2, 8)
+
+	pool.Bytes(0, 8)
+	True(t, pool.inUse[0])
+
+	pool.mu.Lock()
+	delete(pool.inUse, 0)
+	pool.mu.Unlock()
+
+	False(t, pool.inUse[0])
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iouring
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SupportsRegisteredBuffers reports whether the running kernel is new
+// enough for IORING_OP_PROVIDE_BUFFERS / IOSQE_BUFFER_SELECT (introduced in
+// 5.7). Callers should fall back to a per-connection buffer when this
+// returns false.
+func SupportsRegisteredBuffers() bool {
+	major, minor, err := unameKernelVersion()
+	if err != nil {
+		return false
+	}
+
+	return supportsRegisteredBuffersForVersion(major, minor)
+}
+
+// supportsRegisteredBuffersForVersion is the version-comparison half of
+// SupportsRegisteredBuffers, split out so it can be tested against
+// hypothetical major/minor pairs without a real uname(2) call.
+func supportsRegisteredBuffersForVersion(major, minor int) bool {
+	if major != minRegisteredBuffersKernelMajor {
+		return major > minRegisteredBuffersKernelMajor
+	}
+
+	return minor >= minRegisteredBuffersKernelMinor
+}
+
+func unameKernelVersion() (major, minor int, err error) {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return 0, 0, fmt.Errorf("uname error: %w", err)
+	}
+
+	return parseKernelRelease(utsnameToString(uts.Release))
+}
+
+// parseKernelRelease extracts the major/minor version out of a
+// uname(2)-style release string (e.g. "5.15.0-91-generic" or "6.1.55"),
+// ignoring anything past the minor component (patch level, -generic
+// suffix, etc). Split out of unameKernelVersion so the parsing logic can be
+// exercised without a real uname(2) call.
+func parseKernelRelease(release string) (major, minor int, err error) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected kernel release format: %q", release)
+	}
+
+	major, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing kernel major version %q: %w", parts[0], err)
+	}
+
+	minorStr := parts[1]
+	for i, r := range minorStr {
+		if r < '0' || r > '9' {
+			minorStr = minorStr[:i]
+
+			break
+		}
+	}
+
+	minor, err = strconv.Atoi(minorStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parsing kernel minor version %q: %w", minorStr, err)
+	}
+
+	return major, minor, nil
+}
+
+func utsnameToString(field [65]int8) string {
+	b := make([]byte, 0, len(field))
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+
+	return string(b)
+}
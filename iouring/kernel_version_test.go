@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iouring
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func TestParseKernelRelease(t *testing.T) {
+	major, minor, err := parseKernelRelease("5.15.0-91-generic")
+	NoError(t, err)
+	Equal(t, 5, major)
+	Equal(t, 15, minor)
+
+	major, minor, err = parseKernelRelease("6.1.55")
+	NoError(t, err)
+	Equal(t, 6, major)
+	Equal(t, 1, minor)
+
+	_, _, err = parseKernelRelease("not-a-release")
+	Error(t, err)
+
+	_, _, err = parseKernelRelease("5")
+	Error(t, err)
+}
+
+func TestSupportsRegisteredBuffersBoundary(t *testing.T) {
+	cases := []struct {
+		major, minor int
+		want         bool
+	}{
+		{5, 6, false},
+		{5, 7, true},
+		{5, 8, true},
+		{4, 20, false},
+		{6, 0, true},
+	}
+
+	for _, c := range cases {
+		got := supportsRegisteredBuffersForVersion(c.major, c.minor)
+		Equal(t, c.want, got, "major=%d minor=%d", c.major, c.minor)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"net"
+	"sync"
+)
+
+// worker is what a loadBalancer distributes accepted connections across. It
+// is implemented by consumerWorker; tests substitute a lighter fake.
+type worker interface {
+	activeConnections() int
+	setIndex(index int)
+	index() int
+	loop(index int) error
+	shutdown()
+	setSocketAddr(fd int, addr net.Addr)
+	addConnToQueue(fd int) error
+	ringFd() int
+	started() bool
+	// drain calls onDrain for every currently open connection on this
+	// worker, without closing any of them. It's the hook Shutdown uses to
+	// give applications a chance to send a final message (e.g.
+	// Connection: close, a WebSocket close frame, an HTTP/2 GOAWAY)
+	// before activeConnections() is polled down to zero.
+	drain(onDrain func(Conn))
+}
+
+// loadBalancer picks which worker a newly accepted connection is handed to.
+// next is called once per accepted connection from the acceptor's loop, so
+// implementations must be safe to call without blocking on i/o; addr is the
+// client's remote address as reported by acceptor.lastClientAddr, or nil
+// when the strategy doesn't need it (round robin, least connections).
+type loadBalancer interface {
+	register(worker worker)
+	next(addr net.Addr) worker
+	// registered returns every worker currently registered with the
+	// balancer, in no particular order. Shutdown uses it to drain and poll
+	// every worker regardless of which balancing strategy is configured.
+	registered() []worker
+}
+
+type baseLoadBalancer struct {
+	mu      sync.RWMutex
+	workers []worker
+}
+
+func (b *baseLoadBalancer) register(w worker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w.setIndex(len(b.workers))
+	b.workers = append(b.workers, w)
+}
+
+func (b *baseLoadBalancer) registered() []worker {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	workers := make([]worker, len(b.workers))
+	copy(workers, b.workers)
+
+	return workers
+}
+
+type roundRobinLoadBalancer struct {
+	baseLoadBalancer
+	index int
+}
+
+func newRoundRobinLoadBalancer() *roundRobinLoadBalancer {
+	return &roundRobinLoadBalancer{}
+}
+
+func (r *roundRobinLoadBalancer) next(_ net.Addr) worker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w := r.workers[r.index]
+	r.index = (r.index + 1) % len(r.workers)
+
+	return w
+}
+
+type leastConnectionsLoadBalancer struct {
+	baseLoadBalancer
+}
+
+func newLeastConnectionsLoadBalancer() *leastConnectionsLoadBalancer {
+	return &leastConnectionsLoadBalancer{}
+}
+
+func (l *leastConnectionsLoadBalancer) next(_ net.Addr) worker {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	best := l.workers[0]
+	for _, w := range l.workers[1:] {
+		if w.activeConnections() < best.activeConnections() {
+			best = w
+		}
+	}
+
+	return best
+}
@@ -25,17 +25,19 @@ const numberOfTestWorkers = 4
 
 type testWorker struct {
 	conns int
+	idx   int
 }
 
 func (w *testWorker) activeConnections() int {
 	return w.conns
 }
 
-func (w *testWorker) setIndex(_ int) {
+func (w *testWorker) setIndex(index int) {
+	w.idx = index
 }
 
 func (w *testWorker) index() int {
-	return 0
+	return w.idx
 }
 
 func (w *testWorker) loop(_ int) error {
@@ -62,6 +64,9 @@ func (w *testWorker) started() bool {
 	return true
 }
 
+func (w *testWorker) drain(_ func(Conn)) {
+}
+
 func createTestWorkers() []*testWorker {
 	workers := make([]*testWorker, 0)
 	for i := 0; i < numberOfTestWorkers; i++ {
@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pawelgaczynski/gain/pkg/metrics"
+	"github.com/pawelgaczynski/gain/pkg/tracing"
+)
+
+// WithMetricsCollector installs a metrics.Collector that every worker
+// reports accepted/active/read_bytes/write_bytes/close_errors/
+// sqe_overflow counters and accept-to-first-byte/read-to-write latency
+// histograms to. See pkg/metrics/prometheus for a ready-to-use adapter.
+// Without this option the engine uses metrics.NoopCollector.
+func WithMetricsCollector(collector metrics.Collector) Option {
+	return func(options *Options) {
+		options.metricsCollector = collector
+	}
+}
+
+// TracingEventHandler is implemented by an EventHandler that wants an
+// OpenTelemetry span covering a connection's full lifetime. StartSpan is
+// called once, right after OnAccept, and the context.Context it returns is
+// threaded through every subsequent OnRead/OnWrite call for that
+// connection so a single span can carry the whole request. The consumer
+// worker sets the span's gain.ring_fd, gain.worker_index, and
+// gain.remote_addr attributes (the last from acceptor.lastClientAddr)
+// before handing the context back to StartSpan.
+type TracingEventHandler interface {
+	EventHandler
+	StartSpan(conn Conn, ctx context.Context) context.Context
+}
+
+// annotateSpan attaches the ring fd, worker index, and remote address
+// attributes documented on TracingEventHandler to whatever span is active
+// on ctx, if any.
+func annotateSpan(ctx context.Context, ringFD, workerIndex int, remoteAddr string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(
+		tracing.AttributeRingFD.Int(ringFD),
+		tracing.AttributeWorkerIndex.Int(workerIndex),
+		tracing.AttributeRemoteAddr.String(remoteAddr),
+	)
+}
+
+// Context returns the context.Context a TracingEventHandler's StartSpan
+// produced for conn, or context.Background() if tracing isn't configured.
+// OnRead/OnWrite take no context.Context of their own, so a
+// TracingEventHandler implementation that wants the span StartSpan started
+// retrieves it by calling this instead.
+func (conn *connection) Context() context.Context {
+	if conn.ctx == nil {
+		return context.Background()
+	}
+
+	return conn.ctx
+}
+
+// afterAccept runs the accept-time bookkeeping shared by every place a
+// connection transitions out of OnAccept: it seeds acceptedAt for
+// ObserveAcceptToFirstByte, and, when eventHandler implements
+// TracingEventHandler, starts the connection's span, annotates it, and
+// stores the returned context on conn for Context to return later.
+func (c *consumerWorker) afterAccept(conn *connection) {
+	conn.acceptedAt = time.Now()
+
+	handler, ok := c.eventHandler.(TracingEventHandler)
+	if !ok {
+		return
+	}
+
+	remoteAddr := ""
+	if conn.remoteAddr != nil {
+		remoteAddr = conn.remoteAddr.String()
+	}
+
+	ctx := handler.StartSpan(conn, context.Background())
+	annotateSpan(ctx, c.ring.Fd(), c.index(), remoteAddr)
+	conn.ctx = ctx
+}
+
+// recordRead reports n bytes read on conn to the worker's metrics
+// collector, and -- the first time this fires for conn -- observes the
+// accept-to-first-byte latency seeded by afterAccept. It also stamps
+// lastReadAt so a later recordWrite can observe read-to-write latency.
+func (c *consumerWorker) recordRead(conn *connection, n int) {
+	c.config.metricsCollector.AddReadBytes(c.index(), n)
+
+	if !conn.firstByteObserved {
+		c.config.metricsCollector.ObserveAcceptToFirstByte(c.index(), time.Since(conn.acceptedAt))
+		conn.firstByteObserved = true
+	}
+
+	conn.lastReadAt = time.Now()
+}
+
+// recordWrite observes the read-to-write latency between conn's most
+// recent recordRead and now, if any read has happened yet.
+func (c *consumerWorker) recordWrite(conn *connection) {
+	if conn.lastReadAt.IsZero() {
+		return
+	}
+
+	c.config.metricsCollector.ObserveReadToWrite(c.index(), time.Since(conn.lastReadAt))
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http1 is a reference gain.Codec for HTTP/1.1, parsing requests
+// directly out of the connection's zero-copy read buffer instead of
+// allocating a bufio.Reader per connection the way net/http does.
+package http1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/pawelgaczynski/gain"
+)
+
+// ErrIncompleteRequest is a sentinel used internally to signal "not enough
+// bytes yet"; it never escapes Decode, which returns (nil, 0, nil) instead
+// so callers don't need to special-case it.
+var errIncompleteRequest = errors.New("http1: incomplete request")
+
+// ErrMalformedRequest is returned by Decode when buf contains bytes that
+// cannot be a valid HTTP/1.1 request line or header block.
+var ErrMalformedRequest = errors.New("http1: malformed request")
+
+var headerBodySeparator = []byte("\r\n\r\n")
+
+// Request is the Frame produced by Codec.Decode for each complete HTTP/1.1
+// request found in a connection's buffer.
+type Request struct {
+	Method  string
+	Path    string
+	Proto   string
+	Headers map[string]string
+	Body    []byte
+}
+
+// Response is the Frame EventHandler code builds and hands back to
+// Codec.Encode.
+type Response struct {
+	Status  int
+	Reason  string
+	Headers map[string]string
+	Body    []byte
+}
+
+// Codec is a stateless gain.Codec implementation for HTTP/1.1. It is safe
+// to share across every connection on a worker since all per-request state
+// lives on the Request/Response frames it produces.
+type Codec struct{}
+
+var _ gain.Codec = (*Codec)(nil)
+
+// New returns an HTTP/1.1 Codec.
+func New() *Codec {
+	return &Codec{}
+}
+
+// Decode slices one complete HTTP/1.1 request out of buf, or returns a nil
+// frame if buf doesn't yet hold a full request (including its body, per
+// Content-Length). conn is unused by this codec; it exists to satisfy
+// gain.Codec and to let richer codecs key per-connection state off it.
+func (c *Codec) Decode(_ gain.Conn, buf []byte) (gain.Frame, int, error) {
+	headerEnd := bytes.Index(buf, headerBodySeparator)
+	if headerEnd < 0 {
+		return nil, 0, nil
+	}
+
+	req, err := parseRequestHead(buf[:headerEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bodyStart := headerEnd + len(headerBodySeparator)
+
+	contentLength := 0
+	if cl, ok := req.Headers["content-length"]; ok {
+		contentLength, err = strconv.Atoi(cl)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: invalid Content-Length: %s", ErrMalformedRequest, cl)
+		}
+	}
+
+	if len(buf) < bodyStart+contentLength {
+		return nil, 0, nil
+	}
+
+	req.Body = buf[bodyStart : bodyStart+contentLength]
+
+	return req, bodyStart + contentLength, nil
+}
+
+// Encode serializes a *Response into an HTTP/1.1 status line, headers, and
+// body ready to be queued on the connection's write buffer.
+func (c *Codec) Encode(frame gain.Frame) []byte {
+	resp, ok := frame.(*Response)
+	if !ok {
+		return nil
+	}
+
+	if resp.Reason == "" {
+		resp.Reason = statusText(resp.Status)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", resp.Status, resp.Reason)
+
+	if _, ok := resp.Headers["Content-Length"]; !ok {
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(resp.Body))
+	}
+	for k, v := range resp.Headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(resp.Body)
+
+	return buf.Bytes()
+}
+
+func parseRequestHead(head []byte) (*Request, error) {
+	lines := bytes.Split(head, []byte("\r\n"))
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: empty request", ErrMalformedRequest)
+	}
+
+	requestLine := bytes.Fields(lines[0])
+	if len(requestLine) != 3 {
+		return nil, fmt.Errorf("%w: bad request line", ErrMalformedRequest)
+	}
+
+	req := &Request{
+		Method:  string(requestLine[0]),
+		Path:    string(requestLine[1]),
+		Proto:   string(requestLine[2]),
+		Headers: make(map[string]string, len(lines)-1),
+	}
+
+	for _, line := range lines[1:] {
+		if len(line) == 0 {
+			continue
+		}
+
+		sep := bytes.IndexByte(line, ':')
+		if sep < 0 {
+			return nil, fmt.Errorf("%w: bad header line %q", ErrMalformedRequest, line)
+		}
+
+		name := bytes.ToLower(bytes.TrimSpace(line[:sep]))
+		value := bytes.TrimSpace(line[sep+1:])
+		req.Headers[string(name)] = string(value)
+	}
+
+	return req, nil
+}
+
+func statusText(code int) string {
+	switch code {
+	case 200:
+		return "OK"
+	case 204:
+		return "No Content"
+	case 400:
+		return "Bad Request"
+	case 404:
+		return "Not Found"
+	case 500:
+		return "Internal Server Error"
+	default:
+		return ""
+	}
+}
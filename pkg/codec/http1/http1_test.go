@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http1
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func TestCodecDecodeWaitsForFullHeaders(t *testing.T) {
+	codec := New()
+
+	frame, consumed, err := codec.Decode(nil, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n"))
+	NoError(t, err)
+	Nil(t, frame)
+	Equal(t, 0, consumed)
+}
+
+func TestCodecDecodeRequestWithoutBody(t *testing.T) {
+	codec := New()
+
+	buf := []byte("GET /hello HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	frame, consumed, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	Equal(t, len(buf), consumed)
+
+	req, ok := frame.(*Request)
+	True(t, ok)
+	Equal(t, "GET", req.Method)
+	Equal(t, "/hello", req.Path)
+	Equal(t, "HTTP/1.1", req.Proto)
+	Equal(t, "example.com", req.Headers["host"])
+	Empty(t, req.Body)
+}
+
+func TestCodecDecodeRequestWaitsForFullBody(t *testing.T) {
+	codec := New()
+
+	buf := []byte("POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhel")
+	frame, consumed, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	Nil(t, frame)
+	Equal(t, 0, consumed)
+}
+
+func TestCodecDecodeRequestWithBody(t *testing.T) {
+	codec := New()
+
+	buf := []byte("POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	frame, consumed, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	Equal(t, len(buf), consumed)
+
+	req, ok := frame.(*Request)
+	True(t, ok)
+	Equal(t, "hello", string(req.Body))
+}
+
+func TestCodecDecodeRequestWithTrailingBytes(t *testing.T) {
+	codec := New()
+
+	buf := []byte("GET / HTTP/1.1\r\n\r\nGET /next HTTP/1.1\r\n\r\n")
+	frame, consumed, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	NotNil(t, frame)
+	Less(t, consumed, len(buf))
+
+	frame, _, err = codec.Decode(nil, buf[consumed:])
+	NoError(t, err)
+	req, ok := frame.(*Request)
+	True(t, ok)
+	Equal(t, "/next", req.Path)
+}
+
+func TestCodecDecodeMalformedContentLength(t *testing.T) {
+	codec := New()
+
+	_, _, err := codec.Decode(nil, []byte("GET / HTTP/1.1\r\nContent-Length: notanumber\r\n\r\n"))
+	ErrorIs(t, err, ErrMalformedRequest)
+}
+
+func TestCodecDecodeMalformedRequestLine(t *testing.T) {
+	codec := New()
+
+	_, _, err := codec.Decode(nil, []byte("GET\r\n\r\n"))
+	ErrorIs(t, err, ErrMalformedRequest)
+}
+
+func TestCodecEncodeResponse(t *testing.T) {
+	codec := New()
+
+	out := codec.Encode(&Response{
+		Status: 200,
+		Body:   []byte("ok"),
+	})
+
+	Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", string(out))
+}
+
+func TestCodecEncodeIgnoresNonResponseFrame(t *testing.T) {
+	codec := New()
+
+	Nil(t, codec.Encode("not a response"))
+}
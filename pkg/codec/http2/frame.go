@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http2 is a reference gain.Codec for HTTP/2 (RFC 7540), built on
+// top of a single gain connection: one Codec instance multiplexes every
+// stream on that connection through an HPACK decoder/encoder pair and
+// per-stream flow-control windows.
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const frameHeaderLen = 9
+
+// Frame types this codec understands. Settings/ping/goaway/window_update
+// are handled internally by the Codec and never surfaced as a Frame;
+// Decode only ever returns *Headers or *Data to the application.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+const (
+	flagEndStream  = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+var errShortFrame = errors.New("http2: frame header incomplete")
+
+// frameHeader is the 9-byte prefix in front of every HTTP/2 frame.
+type frameHeader struct {
+	length   uint32
+	typ      uint8
+	flags    uint8
+	streamID uint32
+}
+
+// parseFrameHeader reads a frameHeader from the front of buf. It returns
+// errShortFrame if buf is shorter than frameHeaderLen, which the Codec
+// treats the same as "need more bytes" rather than a protocol error.
+func parseFrameHeader(buf []byte) (frameHeader, error) {
+	if len(buf) < frameHeaderLen {
+		return frameHeader{}, errShortFrame
+	}
+
+	length := uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2])
+
+	return frameHeader{
+		length:   length,
+		typ:      buf[3],
+		flags:    buf[4],
+		streamID: binary.BigEndian.Uint32(buf[5:9]) & 0x7fffffff,
+	}, nil
+}
+
+func writeFrameHeader(buf []byte, h frameHeader) {
+	buf[0] = byte(h.length >> 16)
+	buf[1] = byte(h.length >> 8)
+	buf[2] = byte(h.length)
+	buf[3] = h.typ
+	buf[4] = h.flags
+	binary.BigEndian.PutUint32(buf[5:9], h.streamID&0x7fffffff)
+}
+
+func errFrameTooLarge(length, max uint32) error {
+	return fmt.Errorf("http2: frame length %d exceeds max frame size %d", length, max)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http2
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func TestParseFrameHeaderShort(t *testing.T) {
+	_, err := parseFrameHeader(make([]byte, 3))
+	ErrorIs(t, err, errShortFrame)
+}
+
+func TestWriteThenParseFrameHeaderRoundTrip(t *testing.T) {
+	want := frameHeader{
+		length:   42,
+		typ:      frameHeaders,
+		flags:    flagEndHeaders | flagEndStream,
+		streamID: 7,
+	}
+
+	buf := make([]byte, frameHeaderLen)
+	writeFrameHeader(buf, want)
+
+	got, err := parseFrameHeader(buf)
+	NoError(t, err)
+	Equal(t, want, got)
+}
+
+func TestParseFrameHeaderMasksReservedBit(t *testing.T) {
+	buf := make([]byte, frameHeaderLen)
+	writeFrameHeader(buf, frameHeader{streamID: 5})
+	// Set the reserved high bit of the stream ID field, which a
+	// well-behaved peer should never set but RFC 7540 Section 4.1 says
+	// must be ignored on receipt rather than treated as a different stream.
+	buf[5] |= 0x80
+
+	got, err := parseFrameHeader(buf)
+	NoError(t, err)
+	Equal(t, uint32(5), got.streamID)
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/pawelgaczynski/gain"
+)
+
+// ClientPreface is the 24-byte magic every HTTP/2 connection starts with
+// (RFC 7540 Section 3.5). The engine's OnAccept hook (or TLS ALPN
+// negotiation) is expected to strip it before the first Decode call.
+var ClientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+var errUnknownConn = errors.New("http2: codec used with an unregistered connection")
+
+// Headers is the Frame Decode produces once END_HEADERS is seen: a decoded
+// HPACK header block for one stream.
+type Headers struct {
+	StreamID  uint32
+	Fields    []hpack.HeaderField
+	EndStream bool
+}
+
+// Data is the Frame Decode produces for each DATA frame.
+type Data struct {
+	StreamID  uint32
+	Bytes     []byte
+	EndStream bool
+}
+
+// Codec is a gain.Codec implementing the HTTP/2 framing layer: HPACK
+// compression, per-stream flow-control windows, and multiplexing of
+// concurrent streams over the single connection buffer gain exposes. It
+// keeps one connState and one pair of HPACK encoder/decoder per connection,
+// since HPACK's dynamic table is connection-scoped, not stream-scoped.
+type Codec struct {
+	maxFrameSize uint32
+
+	mu    sync.Mutex
+	conns map[gain.Conn]*codecConn
+}
+
+type codecConn struct {
+	state   *connState
+	decoder *hpack.Decoder
+	encoder *hpack.Encoder
+	encBuf  bytes.Buffer
+}
+
+var _ gain.Codec = (*Codec)(nil)
+
+// New returns an HTTP/2 Codec. maxFrameSize bounds the largest frame this
+// codec will accept before returning an error (RFC 7540 Section 4.2); pass
+// 0 to use the protocol default of 16384.
+func New(maxFrameSize uint32) *Codec {
+	if maxFrameSize == 0 {
+		maxFrameSize = 16384
+	}
+
+	return &Codec{
+		maxFrameSize: maxFrameSize,
+		conns:        make(map[gain.Conn]*codecConn),
+	}
+}
+
+func (c *Codec) connFor(conn gain.Conn) *codecConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cc, ok := c.conns[conn]
+	if !ok {
+		cc = &codecConn{state: newConnState()}
+		cc.decoder = hpack.NewDecoder(4096, nil)
+		cc.encoder = hpack.NewEncoder(&cc.encBuf)
+		c.conns[conn] = cc
+	}
+
+	return cc
+}
+
+// Forget drops the HPACK and flow-control state kept for conn. The engine
+// should call this from OnClose so long-lived workers don't leak a
+// codecConn per connection that has already gone away.
+func (c *Codec) Forget(conn gain.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+// Decode consumes one HTTP/2 frame from the front of buf. SETTINGS, PING,
+// WINDOW_UPDATE, PRIORITY, and GOAWAY frames are handled internally (window
+// accounting, etc.) and never surfaced; HEADERS (+ any trailing
+// CONTINUATION frames) are buffered and only returned once END_HEADERS is
+// set, and DATA frames are returned per-frame as *Data.
+func (c *Codec) Decode(conn gain.Conn, buf []byte) (gain.Frame, int, error) {
+	header, err := parseFrameHeader(buf)
+	if err != nil {
+		return nil, 0, nil //nolint:nilerr // errShortFrame just means "need more bytes"
+	}
+
+	if header.length > c.maxFrameSize {
+		return nil, 0, errFrameTooLarge(header.length, c.maxFrameSize)
+	}
+
+	total := frameHeaderLen + int(header.length)
+	if len(buf) < total {
+		return nil, 0, nil
+	}
+
+	payload := buf[frameHeaderLen:total]
+	cc := c.connFor(conn)
+
+	switch header.typ {
+	case frameWindowUpdate:
+		if len(payload) < 4 {
+			return nil, 0, fmt.Errorf("http2: short WINDOW_UPDATE on stream %d", header.streamID)
+		}
+		increment := int32(payload[0]&0x7f)<<24 | int32(payload[1])<<16 | int32(payload[2])<<8 | int32(payload[3])
+		cc.state.applyWindowUpdate(header.streamID, increment)
+
+		return nil, total, nil
+
+	case frameSettings, framePing, framePriority, frameGoAway, frameRSTStream:
+		return nil, total, nil
+
+	case frameData:
+		data := stripPadding(header.flags, payload)
+		cc.state.consumeRecvWindow(header.streamID, int32(len(data)))
+
+		return &Data{
+			StreamID:  header.streamID,
+			Bytes:     data,
+			EndStream: header.flags&flagEndStream != 0,
+		}, total, nil
+
+	case frameHeaders, frameContinuation:
+		return c.decodeHeaders(cc, header, payload, total)
+
+	default:
+		// Unknown frame types are ignored per RFC 7540 Section 4.1.
+		return nil, total, nil
+	}
+}
+
+func (c *Codec) decodeHeaders(cc *codecConn, header frameHeader, payload []byte, total int) (gain.Frame, int, error) {
+	stream := cc.state.stream(header.streamID)
+
+	block := stripPadding(header.flags, payload)
+	if header.typ == frameHeaders && header.flags&flagPriority != 0 {
+		if len(block) < 5 {
+			return nil, 0, fmt.Errorf("http2: short HEADERS priority prefix on stream %d", header.streamID)
+		}
+		block = block[5:]
+	}
+
+	stream.headerBlockBuf = append(stream.headerBlockBuf, block...)
+
+	if header.flags&flagEndHeaders == 0 {
+		return nil, total, nil
+	}
+
+	fields, err := cc.decoder.DecodeFull(stream.headerBlockBuf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("http2: HPACK decode error on stream %d: %w", header.streamID, err)
+	}
+	stream.headerBlockBuf = nil
+
+	endStream := header.flags&flagEndStream != 0
+	stream.endStreamReceived = endStream
+	stream.headersComplete = true
+
+	return &Headers{
+		StreamID:  header.streamID,
+		Fields:    fields,
+		EndStream: endStream,
+	}, total, nil
+}
+
+// Encode serializes a *Headers or *Data Frame back into wire frames,
+// HPACK-encoding header fields against the connection's dynamic table.
+// Encode cannot key its codecConn off a gain.Conn the way Decode does
+// (EventHandler code builds Frames without one in hand), so callers that
+// need per-connection HPACK state must route through EncodeFor instead;
+// Encode on its own only supports Data frames.
+func (c *Codec) Encode(frame gain.Frame) []byte {
+	data, ok := frame.(*Data)
+	if !ok {
+		return nil
+	}
+
+	buf := make([]byte, frameHeaderLen+len(data.Bytes))
+	flags := uint8(0)
+	if data.EndStream {
+		flags = flagEndStream
+	}
+	writeFrameHeader(buf, frameHeader{
+		length:   uint32(len(data.Bytes)),
+		typ:      frameData,
+		flags:    flags,
+		streamID: data.StreamID,
+	})
+	copy(buf[frameHeaderLen:], data.Bytes)
+
+	return buf
+}
+
+// EncodeFor serializes a *Headers Frame for a specific connection, since
+// HPACK encoding must reuse that connection's dynamic table.
+func (c *Codec) EncodeFor(conn gain.Conn, frame *Headers) ([]byte, error) {
+	cc := c.connFor(conn)
+	cc.encBuf.Reset()
+
+	for _, f := range frame.Fields {
+		if err := cc.encoder.WriteField(f); err != nil {
+			return nil, fmt.Errorf("http2: HPACK encode error on stream %d: %w", frame.StreamID, err)
+		}
+	}
+
+	block := cc.encBuf.Bytes()
+	buf := make([]byte, frameHeaderLen+len(block))
+	flags := uint8(flagEndHeaders)
+	if frame.EndStream {
+		flags |= flagEndStream
+	}
+	writeFrameHeader(buf, frameHeader{
+		length:   uint32(len(block)),
+		typ:      frameHeaders,
+		flags:    flags,
+		streamID: frame.StreamID,
+	})
+	copy(buf[frameHeaderLen:], block)
+
+	return buf, nil
+}
+
+func stripPadding(flags uint8, payload []byte) []byte {
+	if flags&flagPadded == 0 || len(payload) == 0 {
+		return payload
+	}
+
+	padLen := int(payload[0])
+	payload = payload[1:]
+	if padLen >= len(payload) {
+		return payload
+	}
+
+	return payload[:len(payload)-padLen]
+}
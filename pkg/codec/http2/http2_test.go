@@ -0,0 +1,186 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http2
+
+import (
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func newFrame(h frameHeader, payload []byte) []byte {
+	buf := make([]byte, frameHeaderLen+len(payload))
+	h.length = uint32(len(payload))
+	writeFrameHeader(buf, h)
+	copy(buf[frameHeaderLen:], payload)
+
+	return buf
+}
+
+func TestDecodeWaitsForFullFrame(t *testing.T) {
+	codec := New(0)
+
+	frame, consumed, err := codec.Decode(nil, newFrame(frameHeader{typ: frameData, streamID: 1}, []byte("hello"))[:frameHeaderLen+2])
+	NoError(t, err)
+	Nil(t, frame)
+	Equal(t, 0, consumed)
+}
+
+func TestDecodeFrameTooLarge(t *testing.T) {
+	codec := New(16)
+
+	buf := newFrame(frameHeader{typ: frameData, streamID: 1}, make([]byte, 32))
+	_, _, err := codec.Decode(nil, buf)
+	Error(t, err)
+}
+
+func TestDecodeDataFrameConsumesRecvWindow(t *testing.T) {
+	codec := New(0)
+
+	buf := newFrame(frameHeader{typ: frameData, flags: flagEndStream, streamID: 1}, []byte("payload"))
+	frame, consumed, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	Equal(t, len(buf), consumed)
+
+	data, ok := frame.(*Data)
+	True(t, ok)
+	Equal(t, uint32(1), data.StreamID)
+	Equal(t, "payload", string(data.Bytes))
+	True(t, data.EndStream)
+
+	cc := codec.connFor(nil)
+	Equal(t, int32(defaultInitialWindowSize-len("payload")), cc.state.stream(1).recvWindow)
+}
+
+func TestDecodeDataFrameStripsPadding(t *testing.T) {
+	codec := New(0)
+
+	payload := append([]byte{3}, append([]byte("abc"), []byte{0, 0, 0}...)...)
+	buf := newFrame(frameHeader{typ: frameData, flags: flagPadded, streamID: 1}, payload)
+
+	frame, _, err := codec.Decode(nil, buf)
+	NoError(t, err)
+	data := frame.(*Data)
+	Equal(t, "abc", string(data.Bytes))
+}
+
+func TestDecodeWindowUpdateAppliesToConnAndStream(t *testing.T) {
+	codec := New(0)
+
+	connUpdate := newFrame(frameHeader{typ: frameWindowUpdate, streamID: 0}, []byte{0, 0, 0, 10})
+	frame, consumed, err := codec.Decode(nil, connUpdate)
+	NoError(t, err)
+	Nil(t, frame)
+	Equal(t, len(connUpdate), consumed)
+
+	cc := codec.connFor(nil)
+	Equal(t, int32(defaultInitialWindowSize+10), cc.state.connSendWindow)
+
+	streamUpdate := newFrame(frameHeader{typ: frameWindowUpdate, streamID: 3}, []byte{0, 0, 0, 20})
+	_, _, err = codec.Decode(nil, streamUpdate)
+	NoError(t, err)
+	Equal(t, int32(defaultInitialWindowSize+20), cc.state.stream(3).sendWindow)
+}
+
+func TestDecodeIgnoresHousekeepingFrames(t *testing.T) {
+	codec := New(0)
+
+	for _, typ := range []uint8{frameSettings, framePing, framePriority, frameGoAway, frameRSTStream} {
+		buf := newFrame(frameHeader{typ: typ, streamID: 1}, []byte("xx"))
+		frame, consumed, err := codec.Decode(nil, buf)
+		NoError(t, err)
+		Nil(t, frame)
+		Equal(t, len(buf), consumed)
+	}
+}
+
+func TestEncodeForThenDecodeHeadersRoundTrip(t *testing.T) {
+	codec := New(0)
+
+	headers := &Headers{
+		StreamID: 1,
+		Fields: []hpack.HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":path", Value: "/"},
+		},
+		EndStream: true,
+	}
+
+	wire, err := codec.EncodeFor(nil, headers)
+	NoError(t, err)
+
+	frame, consumed, err := codec.Decode(nil, wire)
+	NoError(t, err)
+	Equal(t, len(wire), consumed)
+
+	got, ok := frame.(*Headers)
+	True(t, ok)
+	Equal(t, headers.StreamID, got.StreamID)
+	Equal(t, headers.EndStream, got.EndStream)
+	Equal(t, headers.Fields, got.Fields)
+}
+
+func TestDecodeHeadersSpanningContinuation(t *testing.T) {
+	codec := New(0)
+
+	headers := &Headers{
+		StreamID: 1,
+		Fields:   []hpack.HeaderField{{Name: ":method", Value: "POST"}},
+	}
+
+	wire, err := codec.EncodeFor(nil, headers)
+	NoError(t, err)
+	block := wire[frameHeaderLen:]
+
+	split := len(block) / 2
+	if split == 0 {
+		split = 1
+	}
+
+	first := newFrame(frameHeader{typ: frameHeaders, streamID: 1}, block[:split])
+	frame, consumed, err := codec.Decode(nil, first)
+	NoError(t, err)
+	Nil(t, frame)
+	Equal(t, len(first), consumed)
+
+	second := newFrame(frameHeader{typ: frameContinuation, flags: flagEndHeaders, streamID: 1}, block[split:])
+	frame, consumed, err = codec.Decode(nil, second)
+	NoError(t, err)
+	Equal(t, len(second), consumed)
+
+	got, ok := frame.(*Headers)
+	True(t, ok)
+	Equal(t, headers.Fields, got.Fields)
+}
+
+func TestEncodeOnlyHandlesDataFrames(t *testing.T) {
+	codec := New(0)
+
+	Nil(t, codec.Encode(&Headers{}))
+
+	out := codec.Encode(&Data{StreamID: 2, Bytes: []byte("hi")})
+	NotEmpty(t, out)
+}
+
+func TestForgetDropsConnState(t *testing.T) {
+	codec := New(0)
+
+	cc := codec.connFor(nil)
+	codec.Forget(nil)
+
+	NotSame(t, cc, codec.connFor(nil))
+}
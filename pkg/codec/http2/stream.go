@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http2
+
+const defaultInitialWindowSize = 65535
+
+// streamState tracks the minimum per-stream bookkeeping needed for flow
+// control and multiplexing: the send/receive windows and whether the peer
+// has half-closed its side.
+type streamState struct {
+	id                uint32
+	sendWindow        int32
+	recvWindow        int32
+	endStreamReceived bool
+	headersComplete   bool
+	headerBlockBuf    []byte
+}
+
+// connState is the per-connection multiplexing table this Codec keeps,
+// keyed by the gain connection it was created for (see Codec.streamsFor).
+type connState struct {
+	streams           map[uint32]*streamState
+	initialWindowSize int32
+	connSendWindow    int32
+	connRecvWindow    int32
+	settingsAcked     bool
+}
+
+func newConnState() *connState {
+	return &connState{
+		streams:           make(map[uint32]*streamState),
+		initialWindowSize: defaultInitialWindowSize,
+		connSendWindow:    defaultInitialWindowSize,
+		connRecvWindow:    defaultInitialWindowSize,
+	}
+}
+
+func (cs *connState) stream(id uint32) *streamState {
+	s, ok := cs.streams[id]
+	if !ok {
+		s = &streamState{
+			id:         id,
+			sendWindow: cs.initialWindowSize,
+			recvWindow: cs.initialWindowSize,
+		}
+		cs.streams[id] = s
+	}
+
+	return s
+}
+
+// applyWindowUpdate credits a WINDOW_UPDATE increment to either a stream
+// (streamID != 0) or the connection-level window (streamID == 0).
+func (cs *connState) applyWindowUpdate(streamID uint32, increment int32) {
+	if streamID == 0 {
+		cs.connSendWindow += increment
+
+		return
+	}
+
+	cs.stream(streamID).sendWindow += increment
+}
+
+// consumeRecvWindow debits bytes of DATA received on a stream from both the
+// stream and connection receive windows, mirroring how a real peer would
+// need WINDOW_UPDATE frames sent back once these drop low.
+func (cs *connState) consumeRecvWindow(streamID uint32, n int32) {
+	cs.connRecvWindow -= n
+	cs.stream(streamID).recvWindow -= n
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http2
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func TestNewConnStateDefaultsWindows(t *testing.T) {
+	cs := newConnState()
+
+	Equal(t, int32(defaultInitialWindowSize), cs.connSendWindow)
+	Equal(t, int32(defaultInitialWindowSize), cs.connRecvWindow)
+}
+
+func TestStreamCreatedLazilyWithInitialWindow(t *testing.T) {
+	cs := newConnState()
+	cs.initialWindowSize = 1000
+
+	s := cs.stream(3)
+	Equal(t, uint32(3), s.id)
+	Equal(t, int32(1000), s.sendWindow)
+	Equal(t, int32(1000), s.recvWindow)
+
+	Same(t, s, cs.stream(3))
+}
+
+func TestApplyWindowUpdateConnectionLevel(t *testing.T) {
+	cs := newConnState()
+	before := cs.connSendWindow
+
+	cs.applyWindowUpdate(0, 100)
+
+	Equal(t, before+100, cs.connSendWindow)
+}
+
+func TestApplyWindowUpdateStreamLevel(t *testing.T) {
+	cs := newConnState()
+	s := cs.stream(1)
+	before := s.sendWindow
+
+	cs.applyWindowUpdate(1, 50)
+
+	Equal(t, before+50, s.sendWindow)
+}
+
+func TestConsumeRecvWindowDebitsStreamAndConnection(t *testing.T) {
+	cs := newConnState()
+	cs.stream(1)
+
+	connBefore := cs.connRecvWindow
+
+	cs.consumeRecvWindow(1, 200)
+
+	Equal(t, connBefore-200, cs.connRecvWindow)
+	Equal(t, int32(defaultInitialWindowSize)-200, cs.stream(1).recvWindow)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hotrestart implements the zero-downtime upgrade pattern for a
+// gain engine's listening socket: open it with SO_REUSEPORT so an old and a
+// new process can both bind it, then hand the already-open fd from the old
+// process to a freshly spawned successor over a Unix socket using
+// SCM_RIGHTS, so the new binary can start accepting connections while the
+// old one finishes draining (see engine.Shutdown).
+package hotrestart
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// HandoffSocketEnv is set on the spawned successor so it knows which Unix
+// socket to dial to receive the listening fd, instead of guessing a path.
+const HandoffSocketEnv = "GAIN_HOT_RESTART_SOCKET"
+
+// ListenReusePort opens a TCP listener on network/address with SO_REUSEPORT
+// set, so a successor process can bind the same address before this process
+// stops accepting on it.
+func ListenReusePort(network, address string) (*net.TCPListener, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+		},
+	}
+
+	ln, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen with SO_REUSEPORT error: %w", err)
+	}
+
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("hotrestart: %s listener is not a *net.TCPListener", network)
+	}
+
+	return tcpLn, nil
+}
+
+// Spawn execs a copy of the running binary (argv/env unchanged, plus
+// HandoffSocketEnv pointing at a fresh Unix socket) and sends it ln's fd
+// over that socket via SCM_RIGHTS, then waits for the successor to
+// acknowledge receipt before returning. The caller is expected to call
+// engine.Shutdown on the current process's workers once Spawn returns,
+// draining in place while the successor accepts new connections on the
+// handed-off fd.
+func Spawn(ln *net.TCPListener) (*os.Process, error) {
+	socketPath, err := handoffSocketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("listen on handoff socket error: %w", err)
+	}
+	defer listener.Close()
+	defer os.Remove(socketPath)
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...) //nolint:gosec
+	cmd.Env = append(os.Environ(), HandoffSocketEnv+"="+socketPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("spawning successor process error: %w", err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, fmt.Errorf("accepting successor handoff connection error: %w", err)
+	}
+	defer conn.Close()
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("hotrestart: handoff connection is not a *net.UnixConn")
+	}
+
+	if err := sendListenerFD(unixConn, ln); err != nil {
+		return nil, err
+	}
+
+	return cmd.Process, nil
+}
+
+// Receive dials the Unix socket named by HandoffSocketEnv and returns the
+// listening fd its predecessor sent via SCM_RIGHTS, wrapped back up as a
+// *net.TCPListener. It returns ok=false (and a nil error) when
+// HandoffSocketEnv isn't set, i.e. this process was started normally rather
+// than as a hot-restart successor.
+func Receive() (ln *net.TCPListener, ok bool, err error) {
+	socketPath := os.Getenv(HandoffSocketEnv)
+	if socketPath == "" {
+		return nil, false, nil
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("dialing handoff socket error: %w", err)
+	}
+	defer conn.Close()
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, false, fmt.Errorf("hotrestart: handoff connection is not a *net.UnixConn")
+	}
+
+	fd, err := receiveListenerFD(unixConn)
+	if err != nil {
+		return nil, false, err
+	}
+
+	file := os.NewFile(uintptr(fd), "hot-restart-listener")
+	defer file.Close()
+
+	genericLn, err := net.FileListener(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("reconstructing listener from fd error: %w", err)
+	}
+
+	tcpLn, isTCP := genericLn.(*net.TCPListener)
+	if !isTCP {
+		return nil, false, fmt.Errorf("hotrestart: received fd is not a TCP listener")
+	}
+
+	return tcpLn, true, nil
+}
+
+func sendListenerFD(conn *net.UnixConn, ln *net.TCPListener) error {
+	file, err := ln.File()
+	if err != nil {
+		return fmt.Errorf("dup'ing listener fd error: %w", err)
+	}
+	defer file.Close()
+
+	rights := unix.UnixRights(int(file.Fd()))
+
+	_, _, err = conn.WriteMsgUnix([]byte("fd"), rights, nil)
+	if err != nil {
+		return fmt.Errorf("sending fd over SCM_RIGHTS error: %w", err)
+	}
+
+	return nil
+}
+
+func receiveListenerFD(conn *net.UnixConn) (int, error) {
+	oob := make([]byte, unix.CmsgSpace(4))
+	buf := make([]byte, 2)
+
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return 0, fmt.Errorf("receiving fd over SCM_RIGHTS error: %w", err)
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, fmt.Errorf("parsing SCM_RIGHTS control message error: %w", err)
+	}
+	if len(messages) == 0 {
+		return 0, fmt.Errorf("hotrestart: no control messages in handoff")
+	}
+
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil {
+		return 0, fmt.Errorf("parsing unix rights error: %w", err)
+	}
+	if len(fds) == 0 {
+		return 0, fmt.Errorf("hotrestart: no fds in handoff")
+	}
+
+	return fds[0], nil
+}
+
+func handoffSocketPath() (string, error) {
+	f, err := os.CreateTemp("", "gain-hot-restart-*.sock")
+	if err != nil {
+		return "", fmt.Errorf("creating handoff socket path error: %w", err)
+	}
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+
+	return path, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics defines the observability hooks the engine's worker
+// loops call into, independent of which backend (Prometheus, StatsD, ...)
+// actually records them. See pkg/metrics/prometheus for a ready-to-use
+// adapter.
+package metrics
+
+import "time"
+
+// Collector receives per-worker counters and latency observations from the
+// acceptor and consumer worker loops. Every method must be safe to call
+// concurrently from multiple workers; implementations are expected to
+// differentiate workers via the workerIndex argument rather than requiring
+// one Collector per worker.
+type Collector interface {
+	// IncAccepted records one connection accepted by workerIndex.
+	IncAccepted(workerIndex int)
+	// SetActive reports workerIndex's current open connection count.
+	SetActive(workerIndex int, count int)
+	// AddReadBytes records n bytes read on workerIndex.
+	AddReadBytes(workerIndex int, n int)
+	// AddWriteBytes records n bytes written on workerIndex.
+	AddWriteBytes(workerIndex int, n int)
+	// IncCloseErrors records one connection closed due to an error on
+	// workerIndex.
+	IncCloseErrors(workerIndex int)
+	// IncSQEOverflow records one iouring.ErrSQEOverflow occurrence on
+	// workerIndex.
+	IncSQEOverflow(workerIndex int)
+	// ObserveAcceptToFirstByte records the latency between a connection
+	// being accepted and its first OnRead.
+	ObserveAcceptToFirstByte(workerIndex int, d time.Duration)
+	// ObserveReadToWrite records the latency between a connection's last
+	// OnRead and the OnWrite that answered it.
+	ObserveReadToWrite(workerIndex int, d time.Duration)
+}
+
+// NoopCollector implements Collector with every method a no-op. It is the
+// engine's default so that metrics collection is opt-in (see
+// WithMetricsCollector) without every call site needing a nil check.
+type NoopCollector struct{}
+
+var _ Collector = NoopCollector{}
+
+func (NoopCollector) IncAccepted(int)                             {}
+func (NoopCollector) SetActive(int, int)                          {}
+func (NoopCollector) AddReadBytes(int, int)                       {}
+func (NoopCollector) AddWriteBytes(int, int)                      {}
+func (NoopCollector) IncCloseErrors(int)                          {}
+func (NoopCollector) IncSQEOverflow(int)                          {}
+func (NoopCollector) ObserveAcceptToFirstByte(int, time.Duration) {}
+func (NoopCollector) ObserveReadToWrite(int, time.Duration)       {}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prometheus adapts gain's pkg/metrics.Collector onto
+// client_golang, labelling every series by worker so per-worker imbalance
+// (a consistently hot shard, a worker stuck on sqe_overflow) shows up
+// directly in Grafana instead of needing log scraping.
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pawelgaczynski/gain/pkg/metrics"
+)
+
+// Collector is a pkg/metrics.Collector backed by Prometheus client_golang
+// vectors. Register it with a prometheus.Registerer via MustRegister(c)
+// before passing it to gain.WithMetricsCollector.
+type Collector struct {
+	accepted          *prometheus.CounterVec
+	active            *prometheus.GaugeVec
+	readBytes         *prometheus.CounterVec
+	writeBytes        *prometheus.CounterVec
+	closeErrors       *prometheus.CounterVec
+	sqeOverflow       *prometheus.CounterVec
+	acceptToFirstByte *prometheus.HistogramVec
+	readToWrite       *prometheus.HistogramVec
+}
+
+var _ metrics.Collector = (*Collector)(nil)
+
+// New creates a Collector. namespace/subsystem are passed straight through
+// to every metric name, following the usual client_golang convention
+// (namespace_subsystem_name).
+func New(namespace, subsystem string) *Collector {
+	labels := []string{"worker"}
+
+	return &Collector{
+		accepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "accepted_total",
+			Help: "Total connections accepted.",
+		}, labels),
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "active_connections",
+			Help: "Currently open connections.",
+		}, labels),
+		readBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "read_bytes_total",
+			Help: "Total bytes read.",
+		}, labels),
+		writeBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "write_bytes_total",
+			Help: "Total bytes written.",
+		}, labels),
+		closeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "close_errors_total",
+			Help: "Total connections closed due to an error.",
+		}, labels),
+		sqeOverflow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "sqe_overflow_total",
+			Help: "Total io_uring submission queue overflow events.",
+		}, labels),
+		acceptToFirstByte: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "accept_to_first_byte_seconds",
+			Help:    "Latency between accepting a connection and its first OnRead.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		readToWrite: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "read_to_write_seconds",
+			Help:    "Latency between OnRead and the OnWrite that answered it.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+	}
+}
+
+// MustRegister registers every series this Collector owns with reg.
+func (c *Collector) MustRegister(reg prometheus.Registerer) {
+	reg.MustRegister(
+		c.accepted, c.active, c.readBytes, c.writeBytes,
+		c.closeErrors, c.sqeOverflow, c.acceptToFirstByte, c.readToWrite,
+	)
+}
+
+func (c *Collector) IncAccepted(workerIndex int) {
+	c.accepted.WithLabelValues(workerLabel(workerIndex)).Inc()
+}
+
+func (c *Collector) SetActive(workerIndex int, count int) {
+	c.active.WithLabelValues(workerLabel(workerIndex)).Set(float64(count))
+}
+
+func (c *Collector) AddReadBytes(workerIndex int, n int) {
+	c.readBytes.WithLabelValues(workerLabel(workerIndex)).Add(float64(n))
+}
+
+func (c *Collector) AddWriteBytes(workerIndex int, n int) {
+	c.writeBytes.WithLabelValues(workerLabel(workerIndex)).Add(float64(n))
+}
+
+func (c *Collector) IncCloseErrors(workerIndex int) {
+	c.closeErrors.WithLabelValues(workerLabel(workerIndex)).Inc()
+}
+
+func (c *Collector) IncSQEOverflow(workerIndex int) {
+	c.sqeOverflow.WithLabelValues(workerLabel(workerIndex)).Inc()
+}
+
+func (c *Collector) ObserveAcceptToFirstByte(workerIndex int, d time.Duration) {
+	c.acceptToFirstByte.WithLabelValues(workerLabel(workerIndex)).Observe(d.Seconds())
+}
+
+func (c *Collector) ObserveReadToWrite(workerIndex int, d time.Duration) {
+	c.readToWrite.WithLabelValues(workerLabel(workerIndex)).Observe(d.Seconds())
+}
+
+func workerLabel(workerIndex int) string {
+	return strconv.Itoa(workerIndex)
+}
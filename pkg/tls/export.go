@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// exportSessionKeys recovers the traffic secret crypto/tls derived during
+// the handshake -- via the TLS 1.3 keylog format crypto/tls already knows
+// how to write (tlsConfig.KeyLogWriter, see keylog.go) -- and re-derives the
+// kTLS crypto_info key/IV material from it with the same HKDF-Expand-Label
+// construction crypto/tls itself uses internally (RFC 8446 §7.3). This only
+// covers TLS 1.3: the keylog format has no TLS 1.2 equivalent, and TLS 1.2
+// kTLS offload isn't supported by this package.
+//
+// seq is the record sequence number the kernel should start the offloaded
+// direction at; callers are expected to pass 0, since keys are always
+// exported immediately after the handshake finishes, before any application
+// data has been exchanged over the userspace path for that direction.
+func exportSessionKeys(
+	keyLog *keyLogRecorder, server bool, direction Direction, cipherSuite, version uint16, seq uint64,
+) (SessionKeys, error) {
+	if version != tls.VersionTLS13 {
+		return SessionKeys{}, fmt.Errorf(
+			"%w: kernel offload requires TLS 1.3, negotiated %#x", ErrCipherNotOffloadable, version)
+	}
+
+	kernelCipher, ok := kernelCipherType(cipherSuite)
+	if !ok {
+		return SessionKeys{}, fmt.Errorf("%w: cipher suite %#x", ErrCipherNotOffloadable, cipherSuite)
+	}
+
+	label := trafficSecretLabel(direction, server)
+
+	secret, ok := keyLog.secret(label)
+	if !ok {
+		return SessionKeys{}, fmt.Errorf("%w: %s not captured from handshake", ErrCipherNotOffloadable, label)
+	}
+
+	keySize := aesGCM128KeySize
+	if kernelCipher == tlsCipherChacha20Poly {
+		keySize = chacha20poly1305KeySize
+	}
+
+	key := hkdfExpandLabel(secret, "key", nil, keySize)
+	staticIV := hkdfExpandLabel(secret, "iv", nil, staticIVSize)
+
+	keys := SessionKeys{
+		CipherSuite: kernelCipher,
+		Version:     version,
+		Key:         key,
+		SeqNumber:   seq,
+	}
+
+	if kernelCipher == tlsCipherAES128GCM {
+		keys.Salt = staticIV[:aesGCMSaltSize]
+		keys.IV = staticIV[aesGCMSaltSize:]
+	} else {
+		keys.IV = staticIV
+	}
+
+	return keys, nil
+}
+
+// trafficSecretLabel picks the NSS-format keylog label (see the
+// SSLKEYLOGFILE format crypto/tls writes via KeyLogWriter) holding the
+// traffic secret for direction, relative to whether this Handshaker is
+// driving the server or client side of the handshake.
+func trafficSecretLabel(direction Direction, server bool) string {
+	localWrites := direction == DirectionWrite
+	if localWrites == server {
+		return "SERVER_TRAFFIC_SECRET_0"
+	}
+
+	return "CLIENT_TRAFFIC_SECRET_0"
+}
+
+const (
+	aesGCM128KeySize = 16
+	aesGCMSaltSize   = 4
+	staticIVSize     = 12
+)
@@ -0,0 +1,422 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrHandshakeIncomplete is returned by (*Handshaker).Pump while more bytes
+// are still needed before the handshake can finish.
+var ErrHandshakeIncomplete = errors.New("tls: handshake incomplete")
+
+// Handshaker drives a crypto/tls handshake over buffers supplied by the
+// engine's io_uring read/write loop instead of a net.Conn.
+//
+// crypto/tls.Conn.Handshake caches the first result it returns in
+// c.handshakeErr and replays it on every later call instead of resuming, so
+// it cannot be re-invoked once per CQE the way a synthetic, always-erroring
+// net.Conn would require. Handshaker instead runs Handshake exactly once, in
+// a goroutine blocked on pumpConn.Read until Pump has something new to feed
+// it; that goroutine exits as soon as the handshake finishes or fails. Pump
+// itself never blocks the engine loop beyond the time it takes the
+// handshake goroutine to consume the bytes just fed and either produce a
+// flight to send or park again waiting for more.
+type Handshaker struct {
+	conn   *tls.Conn
+	pipe   *pumpConn
+	config *Config
+	keyLog *keyLogRecorder
+
+	startOnce sync.Once
+	appOnce   sync.Once
+	done      bool
+}
+
+// Config mirrors the subset of crypto/tls.Config that callers need to pick a
+// server or client handshake plus the kernel-offload fallback policy.
+type Config struct {
+	TLSConfig *tls.Config
+	// Server, when true, drives a server-side handshake (tls.Server).
+	// Otherwise a client-side handshake (tls.Client) is performed.
+	Server bool
+	// DisableKernelOffload forces every connection onto the userspace
+	// crypto/tls record path, even when the kernel and negotiated cipher
+	// both support kTLS. Useful for kernels without TCP_ULP or for tests.
+	DisableKernelOffload bool
+}
+
+// NewHandshaker creates a Handshaker for a single connection. No I/O happens
+// until Pump is called. config.TLSConfig is never mutated: NewHandshaker
+// clones it so it can install its own KeyLogWriter (needed to recover
+// kTLS-compatible session keys, see export.go) without disturbing a config
+// shared across connections. Any KeyLogWriter the caller already set is
+// chained, not replaced.
+func NewHandshaker(config *Config) *Handshaker {
+	pipe := newPumpConn()
+	keyLog := &keyLogRecorder{chain: config.TLSConfig.KeyLogWriter}
+
+	tlsConfig := config.TLSConfig.Clone()
+	tlsConfig.KeyLogWriter = keyLog
+
+	var conn *tls.Conn
+	if config.Server {
+		conn = tls.Server(pipe, tlsConfig)
+	} else {
+		conn = tls.Client(pipe, tlsConfig)
+	}
+
+	return &Handshaker{
+		conn:   conn,
+		pipe:   pipe,
+		config: config,
+		keyLog: keyLog,
+	}
+}
+
+// start launches the single goroutine that drives conn.Handshake() to
+// completion. Idempotent; only the first call has any effect.
+func (h *Handshaker) start() {
+	h.startOnce.Do(func() {
+		go func() {
+			err := h.conn.Handshake()
+			h.pipe.finishHandshake(err)
+		}()
+	})
+}
+
+// Pump feeds received bytes into the handshake state machine and returns the
+// bytes that must be written back to the peer before the next Pump call. It
+// returns ErrHandshakeIncomplete until the handshake finishes, at which
+// point it returns the final flight (if any) together with a nil error.
+func (h *Handshaker) Pump(received []byte) (toSend []byte, err error) {
+	if h.done {
+		return nil, nil
+	}
+
+	h.start()
+
+	done, handshakeErr := h.pipe.feedAndAwaitHandshake(received)
+	toSend = h.pipe.drainOut()
+
+	if !done {
+		return toSend, ErrHandshakeIncomplete
+	}
+
+	h.done = true
+
+	if handshakeErr != nil {
+		return toSend, fmt.Errorf("tls handshake error: %w", handshakeErr)
+	}
+
+	return toSend, nil
+}
+
+// Done reports whether the handshake has completed.
+func (h *Handshaker) Done() bool {
+	return h.done
+}
+
+// Close unblocks the handshake goroutine (and the app-data reader goroutine,
+// if one was started) so they exit instead of leaking when the underlying
+// connection is closed before a handshake in progress ever finishes. The
+// engine's connection-close path should call this for any connTLSHandshake/
+// connTLSRead connection being torn down.
+func (h *Handshaker) Close() {
+	h.pipe.close()
+	h.pipe.finishHandshake(errPumpClosed)
+	h.pipe.finishAppData(errPumpClosed)
+}
+
+// ConnectionState exposes the negotiated tls.ConnectionState once Done
+// returns true, mirroring EventHandler.OnHandshakeComplete's signature.
+func (h *Handshaker) ConnectionState() tls.ConnectionState {
+	return h.conn.ConnectionState()
+}
+
+// SessionKeysFor extracts kTLS-compatible session keys from the completed
+// handshake, or ErrCipherNotOffloadable if the negotiated cipher suite or
+// TLS version has no kernel crypto_info representation. Callers should fall
+// back to EncryptAppData/DecryptAppData in that case.
+func (h *Handshaker) SessionKeysFor(direction Direction) (SessionKeys, error) {
+	if !h.done {
+		return SessionKeys{}, fmt.Errorf("%w: handshake not complete", ErrCipherNotOffloadable)
+	}
+
+	state := h.conn.ConnectionState()
+
+	// Keys are exported right after the handshake finishes, before any
+	// application data has crossed the userspace path, so both directions'
+	// record sequence numbers are still at the start of the traffic-key
+	// epoch.
+	return exportSessionKeys(h.keyLog, h.config.Server, direction, state.CipherSuite, state.Version, 0)
+}
+
+// EncryptAppData encrypts plaintext with the completed handshake's
+// connection and returns the TLS record(s) ready to ship to the peer. This
+// is the userspace-fallback write path used for the lifetime of a
+// connection that finishTLSHandshake couldn't install into the kernel (see
+// tlsConnState.offloaded in the engine's tls.go).
+func (h *Handshaker) EncryptAppData(plaintext []byte) ([]byte, error) {
+	if !h.done {
+		return nil, fmt.Errorf("tls: EncryptAppData called before handshake completed")
+	}
+
+	if _, err := h.conn.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("tls: encrypting application data: %w", err)
+	}
+
+	return h.pipe.drainOut(), nil
+}
+
+// DecryptAppData feeds received ciphertext into the completed handshake's
+// connection and returns whatever plaintext it decrypted to. Like Pump, it
+// never blocks waiting on i/o: a partial TLS record simply returns (nil,
+// nil) and the rest is expected on a later call. It is the userspace
+// fallback read path used when kTLS offload isn't available.
+func (h *Handshaker) DecryptAppData(received []byte) ([]byte, error) {
+	if !h.done {
+		return nil, fmt.Errorf("tls: DecryptAppData called before handshake completed")
+	}
+
+	h.appOnce.Do(func() {
+		go h.runAppDataReader()
+	})
+
+	plaintext, err := h.pipe.feedAndAwaitAppData(received)
+	if err != nil {
+		return plaintext, fmt.Errorf("tls: decrypting application data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// runAppDataReader loops calling conn.Read once the handshake has completed,
+// decrypting whatever records DecryptAppData has fed it and handing the
+// resulting plaintext back through pipe so DecryptAppData's caller never
+// blocks waiting for a full record to arrive.
+func (h *Handshaker) runAppDataReader() {
+	buf := make([]byte, 16*1024)
+
+	for {
+		n, err := h.conn.Read(buf)
+		if n > 0 {
+			h.pipe.appendPlaintext(buf[:n])
+		}
+		if err != nil {
+			h.pipe.finishAppData(err)
+
+			return
+		}
+	}
+}
+
+// Direction selects which half of the duplex connection session keys are
+// being exported for.
+type Direction int
+
+const (
+	DirectionRead Direction = iota
+	DirectionWrite
+)
+
+// pumpConn adapts the byte-oriented connTLSHandshake/connTLSRead state (fed
+// from addNextRequest) to the net.Conn interface crypto/tls.Conn requires,
+// without ever touching a real socket. Read blocks the handshake/app-data
+// goroutine until more bytes are fed or the pump is finished; Write buffers
+// outbound bytes for the caller to drain and ship via the next Send SQE.
+type pumpConn struct {
+	mu  sync.Mutex
+	cnd *sync.Cond
+
+	in  bytes.Buffer
+	out bytes.Buffer
+
+	// blocked is true whenever the handshake/app-data goroutine is parked
+	// in Read with nothing left to consume -- the signal feedAndAwait*
+	// waits for to know the goroutine has gone quiet rather than still
+	// being mid-flight.
+	blocked bool
+
+	// closed gates Read itself: it must NOT be tied to done, since the same
+	// Read is reused by runAppDataReader after the handshake (and thus
+	// done) has already completed. Only Close() sets this.
+	closed bool
+
+	done    bool
+	doneErr error
+
+	plainOut   bytes.Buffer
+	appDone    bool
+	appDoneErr error
+}
+
+func newPumpConn() *pumpConn {
+	p := &pumpConn{}
+	p.cnd = sync.NewCond(&p.mu)
+
+	return p
+}
+
+// feedAndAwaitHandshake appends received to the input buffer and blocks
+// until the handshake goroutine has either finished or drained the input
+// back to empty (i.e. parked in Read waiting for the next flight).
+func (p *pumpConn) feedAndAwaitHandshake(received []byte) (done bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(received) > 0 {
+		p.in.Write(received)
+		p.blocked = false
+	}
+	p.cnd.Broadcast()
+
+	for !p.done && !(p.blocked && p.in.Len() == 0) {
+		p.cnd.Wait()
+	}
+
+	return p.done, p.doneErr
+}
+
+func (p *pumpConn) finishHandshake(err error) {
+	p.mu.Lock()
+	p.done = true
+	p.doneErr = err
+	p.mu.Unlock()
+	p.cnd.Broadcast()
+}
+
+func (p *pumpConn) drainOut() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.out.Len() == 0 {
+		return nil
+	}
+
+	b := make([]byte, p.out.Len())
+	copy(b, p.out.Bytes())
+	p.out.Reset()
+
+	return b
+}
+
+func (p *pumpConn) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.in.Len() == 0 && !p.closed {
+		p.blocked = true
+		p.cnd.Broadcast()
+		p.cnd.Wait()
+	}
+
+	p.blocked = false
+	if p.in.Len() == 0 {
+		return 0, errPumpClosed
+	}
+
+	return p.in.Read(b)
+}
+
+// close marks the pump permanently closed, unblocking any goroutine parked
+// in Read with nothing left to consume. Called from (*Handshaker).Close.
+func (p *pumpConn) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cnd.Broadcast()
+}
+
+func (p *pumpConn) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.out.Write(b)
+}
+
+func (p *pumpConn) Close() error                       { return nil }
+func (p *pumpConn) LocalAddr() net.Addr                { return nil }
+func (p *pumpConn) RemoteAddr() net.Addr               { return nil }
+func (p *pumpConn) SetDeadline(_ time.Time) error      { return nil }
+func (p *pumpConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (p *pumpConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+// errPumpClosed is surfaced to crypto/tls only when Read is unblocked
+// without new input having arrived, which only happens after finishHandshake
+// has already recorded a result; it never reaches a Pump/DecryptAppData
+// caller.
+var errPumpClosed = errors.New("tls: pump closed")
+
+// --- application-data pump (post-handshake userspace fallback) ---
+
+// feedAndAwaitAppData appends ciphertext to the input buffer and blocks
+// until the app-data reader goroutine has produced plaintext, errored, or
+// drained the input back to empty, then returns whatever plaintext is
+// ready.
+func (p *pumpConn) feedAndAwaitAppData(ciphertext []byte) (plaintext []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(ciphertext) > 0 {
+		p.in.Write(ciphertext)
+		p.blocked = false
+	}
+	p.cnd.Broadcast()
+
+	for !p.appDone && p.plainOut.Len() == 0 && !(p.blocked && p.in.Len() == 0) {
+		p.cnd.Wait()
+	}
+
+	out := p.drainPlaintextLocked()
+	if p.appDone && p.appDoneErr != nil {
+		return out, p.appDoneErr
+	}
+
+	return out, nil
+}
+
+func (p *pumpConn) drainPlaintextLocked() []byte {
+	if p.plainOut.Len() == 0 {
+		return nil
+	}
+
+	b := make([]byte, p.plainOut.Len())
+	copy(b, p.plainOut.Bytes())
+	p.plainOut.Reset()
+
+	return b
+}
+
+func (p *pumpConn) appendPlaintext(b []byte) {
+	p.mu.Lock()
+	p.plainOut.Write(b)
+	p.mu.Unlock()
+	p.cnd.Broadcast()
+}
+
+func (p *pumpConn) finishAppData(err error) {
+	p.mu.Lock()
+	p.appDone = true
+	p.appDoneErr = err
+	p.mu.Unlock()
+	p.cnd.Broadcast()
+}
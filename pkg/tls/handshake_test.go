@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/require"
+)
+
+// testServerConfig returns a minimal TLS 1.3-only server config backed by a
+// freshly generated self-signed certificate, good for exactly one test run.
+func testServerConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gain-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	NoError(t, err)
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		MaxVersion:   tls.VersionTLS13,
+	}
+}
+
+// runHandshake pumps bytes between a server Handshaker and a real
+// crypto/tls.Client dialed over an in-memory net.Pipe, driving the server
+// side exactly the way consumerWorker.pumpTLSHandshake would: feed whatever
+// was just received, ship back whatever Pump produced, repeat until done.
+func runHandshake(t *testing.T, serverConfig *tls.Config) (*Handshaker, *tls.Conn, net.Conn) {
+	t.Helper()
+
+	clientRaw, serverRaw := net.Pipe()
+
+	clientConfig := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13} //nolint:gosec
+	client := tls.Client(clientRaw, clientConfig)
+
+	clientDone := make(chan error, 1)
+	go func() {
+		clientDone <- client.Handshake()
+	}()
+
+	server := NewHandshaker(&Config{TLSConfig: serverConfig, Server: true})
+
+	var received []byte
+	for !server.Done() {
+		toSend, err := server.Pump(received)
+		if len(toSend) > 0 {
+			_, writeErr := serverRaw.Write(toSend)
+			NoError(t, writeErr)
+		}
+		if err == nil {
+			break
+		}
+		if err != ErrHandshakeIncomplete { //nolint:errorlint
+			t.Fatalf("server handshake error: %v", err)
+		}
+
+		buf := make([]byte, 4096)
+		n, readErr := serverRaw.Read(buf)
+		NoError(t, readErr)
+		received = buf[:n]
+	}
+
+	NoError(t, <-clientDone)
+
+	return server, client, serverRaw
+}
+
+func TestHandshakerPumpCompletesOnce(t *testing.T) {
+	server, client, _ := runHandshake(t, testServerConfig(t))
+	defer client.Close()
+	defer server.Close()
+
+	True(t, server.Done())
+	Equal(t, uint16(tls.VersionTLS13), server.ConnectionState().Version)
+
+	// Calling Pump again after completion must be a no-op, not a second
+	// invocation of the cached-result crypto/tls.Conn.Handshake call.
+	toSend, err := server.Pump(nil)
+	NoError(t, err)
+	Nil(t, toSend)
+}
+
+func TestHandshakerSessionKeysForAES128GCM(t *testing.T) {
+	serverConfig := testServerConfig(t)
+	serverConfig.CipherSuites = []uint16{tls.TLS_AES_128_GCM_SHA256}
+
+	server, client, _ := runHandshake(t, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	rx, err := server.SessionKeysFor(DirectionRead)
+	NoError(t, err)
+	Len(t, rx.Key, 16)
+	Len(t, rx.IV, 8)
+	Len(t, rx.Salt, 4)
+
+	tx, err := server.SessionKeysFor(DirectionWrite)
+	NoError(t, err)
+	NotEqual(t, rx.Key, tx.Key)
+}
+
+func TestHandshakerAppDataRoundTrip(t *testing.T) {
+	server, client, serverRaw := runHandshake(t, testServerConfig(t))
+	defer client.Close()
+	defer server.Close()
+
+	clientWrote := make(chan error, 1)
+	go func() {
+		_, err := client.Write([]byte("hello from client"))
+		clientWrote <- err
+	}()
+
+	buf := make([]byte, 4096)
+
+	var plaintext []byte
+	for len(plaintext) == 0 {
+		n, err := serverRaw.Read(buf)
+		NoError(t, err)
+
+		var decErr error
+		plaintext, decErr = server.DecryptAppData(buf[:n])
+		NoError(t, decErr)
+	}
+
+	NoError(t, <-clientWrote)
+	Equal(t, "hello from client", string(plaintext))
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// hkdfExpandLabel implements the TLS 1.3 HKDF-Expand-Label construction
+// (RFC 8446 §7.1) used to derive per-direction "key" and "iv" from a
+// traffic secret. context is always nil for the key/iv derivations
+// exportSessionKeys needs; it exists so the struct HkdfLabel encoding below
+// matches the RFC even though this package never passes a non-empty one.
+func hkdfExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+	hkdfLabel := make([]byte, 0, 2+1+6+len(label)+1+len(context))
+	hkdfLabel = binary.BigEndian.AppendUint16(hkdfLabel, uint16(length))
+
+	fullLabel := "tls13 " + label
+	hkdfLabel = append(hkdfLabel, byte(len(fullLabel)))
+	hkdfLabel = append(hkdfLabel, fullLabel...)
+
+	hkdfLabel = append(hkdfLabel, byte(len(context)))
+	hkdfLabel = append(hkdfLabel, context...)
+
+	return hkdfExpand(secret, hkdfLabel, length)
+}
+
+// hkdfExpand is HKDF-Expand (RFC 5869 §2.3) built directly from
+// crypto/hmac + crypto/sha256 instead of pulling in golang.org/x/crypto/hkdf
+// for these few lines.
+func hkdfExpand(secret, info []byte, length int) []byte {
+	hash := sha256.New
+
+	var (
+		t      []byte
+		okm    []byte
+		blockN byte
+	)
+
+	for len(okm) < length {
+		blockN++
+
+		mac := hmac.New(hash, secret)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{blockN})
+		t = mac.Sum(nil)
+
+		okm = append(okm, t...)
+	}
+
+	return okm[:length]
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// keyLogRecorder implements io.Writer so it can be installed as a
+// crypto/tls.Config.KeyLogWriter. crypto/tls writes one line per secret in
+// the NSS SSLKEYLOGFILE format ("<Label> <ClientRandom-hex> <Secret-hex>"),
+// which is the only supported way to recover a TLS 1.3 traffic secret from
+// the standard library without unsafe/reflection tricks. secret() looks up
+// the most recently captured secret for a label; exportSessionKeys is the
+// only caller.
+type keyLogRecorder struct {
+	mu      sync.Mutex
+	secrets map[string][]byte
+
+	// chain is a caller-supplied KeyLogWriter (e.g. for SSLKEYLOGFILE-based
+	// debugging) that must keep receiving every line NewHandshaker would
+	// otherwise have delivered to it directly.
+	chain io.Writer
+}
+
+func (k *keyLogRecorder) Write(p []byte) (int, error) {
+	if k.chain != nil {
+		if _, err := k.chain.Write(p); err != nil {
+			return 0, fmt.Errorf("tls: forwarding keylog line: %w", err)
+		}
+	}
+
+	label, secret, ok := parseKeyLogLine(p)
+	if ok {
+		k.mu.Lock()
+		if k.secrets == nil {
+			k.secrets = make(map[string][]byte)
+		}
+		k.secrets[label] = secret
+		k.mu.Unlock()
+	}
+
+	return len(p), nil
+}
+
+func (k *keyLogRecorder) secret(label string) ([]byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	secret, ok := k.secrets[label]
+
+	return secret, ok
+}
+
+// parseKeyLogLine extracts the label and decoded secret from a single NSS
+// key log line. Lines crypto/tls doesn't emit a secret we care about (or
+// malformed input) are reported via ok == false rather than an error, since
+// Write must never fail the handshake over an unrecognized line.
+func parseKeyLogLine(line []byte) (label string, secret []byte, ok bool) {
+	fields := bytes.Fields(line)
+	if len(fields) != 3 {
+		return "", nil, false
+	}
+
+	secret, err := hex.DecodeString(string(fields[2]))
+	if err != nil {
+		return "", nil, false
+	}
+
+	return string(fields[0]), secret, true
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Linux kTLS constants. These mirror <linux/tls.h> and are not exposed by the
+// syscall package, so they are declared here.
+const (
+	solTLS                = 282
+	tcpULP                = 31
+	tlsTX                 = 1
+	tlsRX                 = 2
+	tlsVersion12          = 0x0303
+	tlsVersion13          = 0x0304
+	tlsCipherAES128GCM    = 51
+	tlsCipherChacha20Poly = 54
+)
+
+// ErrCipherNotOffloadable is returned by EnableKernelOffload when the
+// negotiated cipher suite has no kTLS crypto_info representation.
+var ErrCipherNotOffloadable = fmt.Errorf("tls: cipher suite has no kernel offload support")
+
+// SessionKeys carries the material the Go TLS state machine negotiates
+// during the handshake that the kernel needs to take over record
+// encryption/decryption via TCP_ULP.
+type SessionKeys struct {
+	CipherSuite uint16
+	Version     uint16
+	Key         []byte
+	IV          []byte
+	Salt        []byte
+	SeqNumber   uint64
+}
+
+// SupportsOffload reports whether a kernel crypto_info cipher type (as
+// returned by kernelCipherType, not a raw IANA TLS cipher suite ID) can be
+// installed into the kernel TLS fast path.
+func SupportsOffload(cipherType uint16) bool {
+	switch cipherType {
+	case tlsCipherAES128GCM, tlsCipherChacha20Poly:
+		return true
+	default:
+		return false
+	}
+}
+
+// kernelCipherType maps an IANA TLS 1.3 cipher suite ID (as found in
+// tls.ConnectionState.CipherSuite) to the kernel's crypto_info cipher_type
+// enum (<linux/tls.h>'s TLS_CIPHER_AES_GCM_128 / TLS_CIPHER_CHACHA20_POLY1305).
+// It reports false for any suite without a kTLS encoding, notably every
+// TLS 1.2 suite and AES-256-GCM (no crypto_info struct defined upstream).
+func kernelCipherType(ianaSuite uint16) (uint16, bool) {
+	switch ianaSuite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		return tlsCipherAES128GCM, true
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		return tlsCipherChacha20Poly, true
+	default:
+		return 0, false
+	}
+}
+
+// EnableKernelOffload installs negotiated session keys into the socket via
+// setsockopt(TCP_ULP, "tls") followed by SOL_TLS/TLS_TX and SOL_TLS/TLS_RX,
+// so that subsequent io_uring Send/Recv operations on fd traverse the kernel
+// TLS fast path instead of userspace crypto/tls record (de)framing.
+func EnableKernelOffload(fd int, rx, tx SessionKeys) error {
+	if !SupportsOffload(rx.CipherSuite) || !SupportsOffload(tx.CipherSuite) {
+		return ErrCipherNotOffloadable
+	}
+
+	err := syscall.SetsockoptString(fd, syscall.SOL_TCP, tcpULP, "tls")
+	if err != nil {
+		return fmt.Errorf("setsockopt(TCP_ULP, tls) error: %w", err)
+	}
+
+	txInfo, err := cryptoInfo(tx)
+	if err != nil {
+		return fmt.Errorf("building TLS_TX crypto_info error: %w", err)
+	}
+
+	err = setsockoptCryptoInfo(fd, tlsTX, txInfo)
+	if err != nil {
+		return fmt.Errorf("setsockopt(SOL_TLS, TLS_TX) error: %w", err)
+	}
+
+	rxInfo, err := cryptoInfo(rx)
+	if err != nil {
+		return fmt.Errorf("building TLS_RX crypto_info error: %w", err)
+	}
+
+	err = setsockoptCryptoInfo(fd, tlsRX, rxInfo)
+	if err != nil {
+		return fmt.Errorf("setsockopt(SOL_TLS, TLS_RX) error: %w", err)
+	}
+
+	return nil
+}
+
+// cryptoInfo encodes SessionKeys into the wire layout expected by the kernel
+// (struct tls12_crypto_info_aes_gcm_128 / ...chacha20_poly1305).
+func cryptoInfo(keys SessionKeys) ([]byte, error) {
+	switch keys.CipherSuite {
+	case tlsCipherAES128GCM:
+		return aesGCMCryptoInfo(keys)
+	case tlsCipherChacha20Poly:
+		return chacha20Poly1305CryptoInfo(keys)
+	default:
+		return nil, ErrCipherNotOffloadable
+	}
+}
+
+func aesGCMCryptoInfo(keys SessionKeys) ([]byte, error) {
+	const (
+		ivSize   = 8
+		keySize  = 16
+		saltSize = 4
+	)
+	if len(keys.Key) != keySize || len(keys.IV) != ivSize || len(keys.Salt) != saltSize {
+		return nil, fmt.Errorf("%w: unexpected AES-128-GCM key material sizes", ErrCipherNotOffloadable)
+	}
+
+	info := make([]byte, 0, 2+2+saltSize+ivSize+keySize+8)
+	info = appendUint16(info, keys.Version)
+	info = appendUint16(info, tlsCipherAES128GCM)
+	info = append(info, keys.Salt...)
+	info = append(info, keys.IV...)
+	info = append(info, keys.Key...)
+	info = appendUint64(info, keys.SeqNumber)
+
+	return info, nil
+}
+
+func chacha20Poly1305CryptoInfo(keys SessionKeys) ([]byte, error) {
+	const (
+		ivSize  = 12
+		keySize = chacha20poly1305KeySize
+	)
+	if len(keys.Key) != keySize || len(keys.IV) != ivSize {
+		return nil, fmt.Errorf("%w: unexpected ChaCha20-Poly1305 key material sizes", ErrCipherNotOffloadable)
+	}
+
+	info := make([]byte, 0, 2+2+ivSize+keySize+8)
+	info = appendUint16(info, keys.Version)
+	info = appendUint16(info, tlsCipherChacha20Poly)
+	info = append(info, keys.IV...)
+	info = append(info, keys.Key...)
+	info = appendUint64(info, keys.SeqNumber)
+
+	return info, nil
+}
+
+const chacha20poly1305KeySize = 32
+
+// setsockoptCryptoInfo installs a crypto_info struct (built by cryptoInfo)
+// at SOL_TLS/optname (TLS_TX or TLS_RX). syscall has no typed helper for
+// SOL_TLS, so this goes through the raw setsockopt syscall the way
+// syscall.SetsockoptString does internally.
+func setsockoptCryptoInfo(fd int, optname int, info []byte) error {
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_SETSOCKOPT,
+		uintptr(fd),
+		uintptr(solTLS),
+		uintptr(optname),
+		uintptr(unsafe.Pointer(&info[0])),
+		uintptr(len(info)),
+		0,
+	)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+
+	return b
+}
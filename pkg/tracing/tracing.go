@@ -0,0 +1,29 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing holds the span attribute keys the engine's OpenTelemetry
+// integration attaches to a connection's request-lifetime span, shared
+// between the engine (which sets them) and application code (which may
+// want to read them back off the context for logging).
+package tracing
+
+import "go.opentelemetry.io/otel/attribute"
+
+// Attribute keys set on the span covering a connection's full lifetime,
+// from OnAccept's StartSpan through the connection's close.
+const (
+	AttributeRingFD      = attribute.Key("gain.ring_fd")
+	AttributeWorkerIndex = attribute.Key("gain.worker_index")
+	AttributeRemoteAddr  = attribute.Key("gain.remote_addr")
+)
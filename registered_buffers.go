@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"fmt"
+
+	"github.com/pawelgaczynski/gain/iouring"
+)
+
+// registeredBuffersGroupID is the IORING_OP_PROVIDE_BUFFERS group ID each
+// consumer worker registers its pool under. Group IDs only need to be
+// unique within a ring, and each worker owns its own ring, so every worker
+// can reuse the same constant.
+const registeredBuffersGroupID = 0
+
+// WithRegisteredBuffers registers a kernel-managed buffer group of count
+// buffers, size bytes each, via IORING_OP_PROVIDE_BUFFERS at ring startup,
+// intended to replace each connection's own buffer for the read path on
+// kernel 5.7+ -- cutting per-connection memory substantially for
+// idle-heavy (C10K-style) workloads and removing a copy on the hot read
+// path. That replacement isn't wired up yet: addNextRequest doesn't OR
+// IOSQE_BUFFER_SELECT into the read SQE (see the comment on
+// consumerWorker.beginNextRead), so today this only reserves the buffer
+// group with the kernel -- every read still goes through the existing
+// per-connection buffer regardless of count/size.
+func WithRegisteredBuffers(count, size int) Option {
+	return func(options *Options) {
+		options.registeredBufferCount = count
+		options.registeredBufferSize = size
+	}
+}
+
+// registeredBuffersEnabled reports whether a worker should set up a
+// BufferPool instead of allocating one buffer per connection, taking the
+// running kernel's support for IOSQE_BUFFER_SELECT into account.
+func registeredBuffersEnabled(count, size int) bool {
+	return count > 0 && size > 0 && iouring.SupportsRegisteredBuffers()
+}
+
+// newRegisteredBufferPool builds and registers a worker's BufferPool with
+// the kernel when config calls for one, or returns a nil pool (and no
+// error) when registered buffers aren't enabled or the kernel is too old --
+// the worker falls back to its existing per-connection buffer in that case.
+func newRegisteredBufferPool(ring *iouring.Ring, count, size int) (*iouring.BufferPool, error) {
+	if !registeredBuffersEnabled(count, size) {
+		return nil, nil //nolint:nilnil
+	}
+
+	pool := iouring.NewBufferPool(ring, registeredBuffersGroupID, count, size)
+	if err := pool.Provide(); err != nil {
+		return nil, fmt.Errorf("registering buffer pool: %w", err)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"context"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown re-checks activeConnections()
+// while draining. There's no completion event for "a connection closed
+// itself", so this is a plain poll rather than a wakeup.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// DrainEventHandler is implemented by an EventHandler that wants a chance
+// to tell its peer the connection is going away (a Connection: close
+// header, an HTTP/2 GOAWAY, a WebSocket close frame) before Shutdown force
+// closes whatever hasn't drained by the time ctx expires.
+type DrainEventHandler interface {
+	EventHandler
+	OnDrain(conn Conn)
+}
+
+// Shutdown drains the engine instead of hard-closing every connection the
+// way closeAllConns does: it stops the acceptor from admitting new
+// connections, gives every open connection a chance to see OnDrain, then
+// waits for activeConnections() across every worker to reach zero or for
+// ctx to expire, whichever comes first, before force-closing whatever is
+// left.
+func (e *engine) Shutdown(ctx context.Context) error {
+	e.acceptor.stop()
+
+	if handler, ok := e.eventHandler.(DrainEventHandler); ok {
+		for _, w := range e.balancer.registered() {
+			w.drain(handler.OnDrain)
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if e.totalActiveConnections() == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return e.closeRemainingConns(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return e.closeRemainingConns(nil)
+}
+
+func (e *engine) totalActiveConnections() int {
+	total := 0
+	for _, w := range e.balancer.registered() {
+		total += w.activeConnections()
+	}
+
+	return total
+}
+
+func (e *engine) closeRemainingConns(shutdownErr error) error {
+	for _, w := range e.balancer.registered() {
+		w.shutdown()
+	}
+
+	return shutdownErr
+}
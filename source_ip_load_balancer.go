@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import "net"
+
+// sourceIPHashLoadBalancer is the simpler sibling of
+// consistentHashLoadBalancer: it hashes only the client's IP (not the full
+// net.Addr, which would include an ephemeral port that changes every
+// reconnect) directly into the worker slice. It doesn't rebalance on
+// register/deregister the way a ring does, so it's cheaper but reshuffles
+// every client's worker assignment whenever the worker count changes.
+type sourceIPHashLoadBalancer struct {
+	baseLoadBalancer
+}
+
+func newSourceIPHashLoadBalancer() *sourceIPHashLoadBalancer {
+	return &sourceIPHashLoadBalancer{}
+}
+
+func (s *sourceIPHashLoadBalancer) next(addr net.Addr) worker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := fnv1a64(sourceIP(addr))
+	idx := int(hash % uint64(len(s.workers)))
+
+	return s.workers[idx]
+}
+
+// sourceIP extracts the raw IP bytes to hash from addr, falling back to
+// addr.String() for address types this package doesn't special-case.
+func sourceIP(addr net.Addr) []byte {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	default:
+		return []byte(addr.String())
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/stretchr/testify/require"
+)
+
+func TestSourceIPHashLoadBalancerSticky(t *testing.T) {
+	lb := newSourceIPHashLoadBalancer()
+
+	workers := createTestWorkers()
+	for _, worker := range workers {
+		lb.register(worker)
+	}
+
+	clientA := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51000}
+	clientB := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 51001}
+
+	first := lb.next(clientA)
+	for i := 0; i < 10; i++ {
+		Same(t, first, lb.next(&net.TCPAddr{IP: clientA.IP, Port: 40000 + i}))
+	}
+
+	secondFirst := lb.next(clientB)
+	for i := 0; i < 10; i++ {
+		Same(t, secondFirst, lb.next(&net.TCPAddr{IP: clientB.IP, Port: 45000 + i}))
+	}
+}
+
+func TestConsistentHashLoadBalancerSticky(t *testing.T) {
+	lb := newConsistentHashLoadBalancer(func(addr net.Addr) uint64 {
+		return fnv1a64(sourceIP(addr))
+	})
+
+	workers := createTestWorkers()
+	for _, worker := range workers {
+		lb.register(worker)
+	}
+
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.1.42"), Port: 51000}
+
+	first := lb.next(client)
+	for i := 0; i < 20; i++ {
+		Same(t, first, lb.next(&net.TCPAddr{IP: client.IP, Port: 40000 + i}))
+	}
+}
+
+func TestConsistentHashLoadBalancerBoundedLoad(t *testing.T) {
+	lb := newConsistentHashLoadBalancer(func(addr net.Addr) uint64 {
+		return fnv1a64(sourceIP(addr))
+	})
+
+	workers := createTestWorkers()
+	for _, worker := range workers {
+		lb.register(worker)
+	}
+
+	client := &net.TCPAddr{IP: net.ParseIP("172.16.0.7"), Port: 51000}
+	primary := lb.next(client)
+	primary.(*testWorker).conns = 1000
+
+	rerouted := lb.next(client)
+	NotSame(t, primary, rerouted)
+	True(t, float64(rerouted.(*testWorker).conns) <= lb.boundedLoadThresholdLocked())
+}
+
+func TestConsistentHashLoadBalancerDeregisterRebuildsRing(t *testing.T) {
+	lb := newConsistentHashLoadBalancer(func(addr net.Addr) uint64 {
+		return fnv1a64(sourceIP(addr))
+	})
+
+	workers := createTestWorkers()
+	for _, worker := range workers {
+		lb.register(worker)
+	}
+
+	removed := workers[1]
+	lb.deregister(removed)
+
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 6000}
+	for i := 0; i < 50; i++ {
+		NotSame(t, removed, lb.next(&net.TCPAddr{IP: client.IP, Port: 6000 + i}))
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023 Paweł Gaczyński
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gain
+
+import (
+	stdtls "crypto/tls"
+
+	"github.com/pawelgaczynski/gain/pkg/tls"
+)
+
+// The TLS handshake borrows one extra connection state so that handshake
+// bytes flow through the same addNextRequest/onRead pump as plaintext
+// traffic instead of a dedicated goroutine per connection. connTLSHandshake
+// pumps ClientHello..Finished. connTLSRead is the userspace-fallback read
+// state a connection settles into instead of connRead when
+// finishTLSHandshake couldn't install the negotiated keys into the kernel:
+// every received byte still has to pass through the Handshaker's
+// crypto/tls.Conn via DecryptAppData rather than the raw socket bytes the
+// kTLS fast path would otherwise allow through untouched. Writes need no
+// equivalent connTLSWrite completion state -- a write completion is just a
+// byte count regardless of what was sent -- they only need to be encrypted
+// before being queued, which is why consumerWorker shadows
+// readWriteWorkerImpl's addWriteRequest below instead of adding a sibling
+// helper every write call site would have to remember to use.
+//
+// Known limitation: a connection that made it onto the kTLS fast path
+// (state.offloaded, below) settles into plain connRead/connWrite and reads
+// its application data through the plain read/recv path onRead already
+// uses. The kernel also delivers a peer-initiated TLS 1.3 KeyUpdate to that
+// same fd, but as a non-application-data record, which a plain read/recv
+// cannot distinguish from application data -- only recvmsg(2) with its
+// SOL_TLS/TLS_GET_RECORD_TYPE control message can. Nothing in this package
+// submits reads that way (io_uring's IORING_OP_RECVMSG isn't wired into the
+// worker read path at all), so a peer-initiated KeyUpdate on an offloaded
+// connection currently surfaces to onRead as undecodable bytes rather than
+// being intercepted and re-keyed transparently; the connection must be
+// closed and re-established rather than rekeyed in place.
+const (
+	connTLSHandshake = iota + 1000
+	connTLSRead
+)
+
+// TLSConfig configures the TLS termination subsystem for an engine. When
+// set via WithTLSConfig, every accepted connection starts in
+// connTLSHandshake instead of connRead until the handshake completes.
+type TLSConfig struct {
+	*stdtls.Config
+
+	// DisableKernelOffload keeps every connection on the userspace
+	// crypto/tls record path. Set automatically when the running kernel or
+	// negotiated cipher suite doesn't support TCP_ULP kTLS offload.
+	DisableKernelOffload bool
+}
+
+// WithTLSConfig enables TLS termination for the engine. The handshake is
+// driven by a Go TLS state machine; once session keys are negotiated the
+// connection is installed into the kernel TLS fast path via TCP_ULP unless
+// config.DisableKernelOffload is set or the kernel/cipher don't support it,
+// in which case records continue to flow through userspace crypto/tls.
+func WithTLSConfig(config TLSConfig) Option {
+	return func(options *Options) {
+		options.tlsConfig = &config
+	}
+}
+
+// TLSEventHandler is implemented by an EventHandler that wants to be
+// notified once a connection's TLS handshake finishes. Plain EventHandler
+// implementations that don't need this are unaffected since the consumer
+// worker only invokes it behind a type assertion.
+type TLSEventHandler interface {
+	OnHandshakeComplete(conn Conn, state stdtls.ConnectionState)
+}
+
+// tlsConnState holds the per-connection handshake/offload bookkeeping. It is
+// attached to a connection for the lifetime of connTLSHandshake and dropped
+// once the connection settles back into connRead/connWrite on the kTLS fast
+// path (or, on fallback, wraps every subsequent read/write).
+type tlsConnState struct {
+	handshaker *tls.Handshaker
+	offloaded  bool
+}
+
+// beginTLSHandshake switches a freshly accepted connection into
+// connTLSHandshake and prepares its Handshaker.
+func (c *consumerWorker) beginTLSHandshake(conn *connection) error {
+	conn.state = connTLSHandshake
+	conn.tls = &tlsConnState{
+		handshaker: tls.NewHandshaker(&tls.Config{
+			TLSConfig: c.config.tlsConfig.Config,
+			Server:    true,
+		}),
+	}
+
+	return c.addNextRequest(conn)
+}
+
+// pumpTLSHandshake feeds bytes received on a connTLSHandshake connection
+// into the Handshaker, ships back whatever flight it produced, and -- once
+// the handshake finishes -- either installs the negotiated keys into the
+// kernel via TCP_ULP or falls back to wrapping the connection's read/write
+// path in userspace crypto/tls, before transitioning back to connRead.
+func (c *consumerWorker) pumpTLSHandshake(conn *connection, received []byte) error {
+	state := conn.tls
+	toSend, err := state.handshaker.Pump(received)
+	if len(toSend) > 0 {
+		if writeErr := c.addWriteRequest(conn, toSend); writeErr != nil {
+			return writeErr
+		}
+	}
+
+	if err == tls.ErrHandshakeIncomplete { //nolint:errorlint
+		return c.addNextRequest(conn)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.finishTLSHandshake(conn, state)
+
+	if handler, ok := c.eventHandler.(TLSEventHandler); ok {
+		handler.OnHandshakeComplete(conn, state.handshaker.ConnectionState())
+	}
+
+	// finishTLSHandshake only sets state.offloaded on success: a connection
+	// that made it into the kernel TLS fast path can go back to the plain
+	// connRead/connWrite states since TCP_ULP now (de)crypts records
+	// transparently, but one that didn't still needs every read and write
+	// routed through the Handshaker's crypto/tls.Conn.
+	if state.offloaded {
+		conn.state = connRead
+	} else {
+		conn.state = connTLSRead
+	}
+
+	return c.addNextRequest(conn)
+}
+
+// pumpTLSRead decrypts ciphertext received on a userspace-fallback
+// connection and dispatches whatever plaintext comes out to eventHandler,
+// mirroring what the connRead path does for an un-encrypted connection.
+func (c *consumerWorker) pumpTLSRead(conn *connection, received []byte) error {
+	plaintext, err := conn.tls.handshaker.DecryptAppData(received)
+	if len(plaintext) > 0 {
+		conn.setUserSpace()
+		c.eventHandler.OnRead(conn, plaintext)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.addNextRequest(conn)
+}
+
+// addWriteRequest shadows readWriteWorkerImpl's method of the same name so
+// every write this worker submits -- handshake flights, plain connWrite
+// bytes, and application data alike -- passes through one chokepoint that
+// knows about TLS. A connTLSRead connection has fallen back to userspace
+// crypto/tls because finishTLSHandshake couldn't install kTLS offload, so
+// its bytes aren't ciphertext yet the way an offloaded connection's
+// kernel-encrypted writes are; this wraps them with EncryptAppData before
+// handing off to the embedded implementation. Every other state (including
+// connTLSHandshake, whose flights are already TLS records produced by the
+// Handshaker itself) passes through unchanged.
+func (c *consumerWorker) addWriteRequest(conn *connection, data []byte) error {
+	if conn.state != connTLSRead {
+		return c.readWriteWorkerImpl.addWriteRequest(conn, data)
+	}
+
+	ciphertext, err := conn.tls.handshaker.EncryptAppData(data)
+	if err != nil {
+		return err
+	}
+
+	return c.readWriteWorkerImpl.addWriteRequest(conn, ciphertext)
+}
+
+// finishTLSHandshake installs the negotiated session into the kernel kTLS
+// fast path when possible, otherwise leaves state.offloaded false so the
+// read/write path keeps routing bytes through the userspace Handshaker's
+// underlying crypto/tls.Conn.
+func (c *consumerWorker) finishTLSHandshake(conn *connection, state *tlsConnState) {
+	if c.config.tlsConfig.DisableKernelOffload {
+		return
+	}
+
+	rx, err := state.handshaker.SessionKeysFor(tls.DirectionRead)
+	if err != nil {
+		c.logDebug().Err(err).Int("fd", conn.fd).Msg("TLS kernel offload unavailable, using userspace crypto/tls")
+
+		return
+	}
+
+	txKeys, err := state.handshaker.SessionKeysFor(tls.DirectionWrite)
+	if err != nil {
+		c.logDebug().Err(err).Int("fd", conn.fd).Msg("TLS kernel offload unavailable, using userspace crypto/tls")
+
+		return
+	}
+
+	if err := tls.EnableKernelOffload(conn.fd, rx, txKeys); err != nil {
+		c.logDebug().Err(err).Int("fd", conn.fd).Msg("kTLS offload failed, using userspace crypto/tls")
+
+		return
+	}
+
+	state.offloaded = true
+}